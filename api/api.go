@@ -2,6 +2,8 @@ package api
 
 import (
 	"context"
+	"io"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	format "github.com/ipfs/go-ipld-format"
@@ -16,10 +18,300 @@ type PBar struct {
 	Msg     string
 }
 
+// ImportOpts specifies how files are encoded into unixfs dags
+type ImportOpts struct {
+	// CidVersion is the cid version of the produced nodes, 0 or 1
+	CidVersion int
+	// HashFunc is the multihash function name, e.g. sha2-256 or blake2b-256
+	HashFunc string
+	// RawLeaves stores file data in raw blocks instead of unixfs nodes
+	RawLeaves bool
+	// Chunker is the chunker spec, e.g. size-262144, rabin-min-avg-max or
+	// buzhash, fixed 1MiB chunks are used if empty
+	Chunker string
+	// Layout is the dag layout, balanced (default) or trickle
+	Layout string
+	// MaxLinks is the max number of links per node, 0 means the default of
+	// the layout
+	MaxLinks int
+	// OnlyHash computes the dag without storing any block
+	OnlyHash bool
+	// NoCopy keeps raw leaves as references to the source file in the
+	// filestore instead of storing their data, requires raw leaves
+	NoCopy bool
+	// PreserveMode records the unixfs 1.5 mode of files and directories
+	PreserveMode bool
+	// PreserveMtime records the unixfs 1.5 mtime of files and directories
+	PreserveMtime bool
+	// Pin pins the root recursively once it is imported, if set
+	Pin *PinOpts
+}
+
+// GetOpts specifies how Get writes files
+type GetOpts struct {
+	// Resume continues partial files from their length instead of writing
+	// them from the start
+	Resume bool
+	// Verify re-imports the written files and checks they get the requested
+	// cids
+	Verify bool
+}
+
+// CatChunk is a piece of the data streamed by Cat and GetTar, Err is set if
+// reading failed and ends the stream
+type CatChunk struct {
+	Data []byte
+	Err  string
+	// EOF marks the last chunk of a complete stream, a stream closed
+	// without it was cut short
+	EOF bool
+}
+
+// LsEntry is a directory entry or a file chunk listed by Ls
+type LsEntry struct {
+	// Name is empty for file chunks
+	Name string
+	Cid  cid.Cid
+	// Type is one of file, dir or symlink, raw for raw leaf chunks
+	Type string
+	// Size is the file size of files and chunks, the cumulative block size
+	// of directories and the target length of symlinks
+	Size uint64
+	// Offset is the offset of a chunk in its file
+	Offset uint64
+	// Err is set if listing failed and ends the stream
+	Err string
+}
+
+// DagStatOpts specifies how a dag is walked for its stats
+type DagStatOpts struct {
+	// Concurrent is the number of blocks loaded at a time
+	Concurrent int
+	// Offline only reads the local blockstore, blocks are fetched from the
+	// network otherwise
+	Offline bool
+	// Timeout is the number of seconds to wait for each block from the
+	// network, 0 waits until the request is canceled
+	Timeout uint
+}
+
+// DagStats are the cumulative stats of the unique blocks of a dag
+type DagStats struct {
+	Blocks int64
+	// Bytes is the total size of the blocks
+	Bytes uint64
+	// FileSize is the unixfs file data held by the blocks
+	FileSize uint64
+	// MaxDepth is the depth of the deepest block below the root
+	MaxDepth int
+	// Missing is the number of blocks which could not be loaded, they are
+	// not part of the other counts
+	Missing int64
+}
+
+// DagCheckResult tells whether all the blocks of a dag are stored locally
+type DagCheckResult struct {
+	Complete bool
+	// Blocks is the number of unique blocks found locally
+	Blocks  int64
+	Missing int64
+	// MissingCids are the first missing blocks found, up to the requested
+	// number
+	MissingCids []cid.Cid
+}
+
+// DagVerifyOpts specifies how DagVerify walks and repairs a dag
+type DagVerifyOpts struct {
+	// Concurrent is the number of blocks verified at a time
+	Concurrent int
+	// Repair fetches mismatched and undecodable blocks again from the
+	// network and replaces them, the bad data is moved to the quarantine
+	Repair bool
+	// Timeout is the number of seconds to wait for each repaired block, 0
+	// waits until the request is canceled
+	Timeout uint
+}
+
+// DagVerifyRef is the verify result of a block of a dag
+type DagVerifyRef struct {
+	Cid cid.Cid
+	// Status is one of ok, missing, mismatch, undecodable or error
+	Status string
+	// Repaired is set if a bad block was replaced by a good one
+	Repaired bool
+	Err      string
+}
+
+// DagPutOpts specifies how DagPut decodes and stores a node
+type DagPutOpts struct {
+	// InputCodec is the codec of the input, dag-json (default), dag-cbor,
+	// dag-pb or raw
+	InputCodec string
+	// StoreCodec is the codec of the stored block, dag-cbor (default),
+	// dag-json, dag-pb or raw
+	StoreCodec string
+	// HashFunc is the multihash function name, sha2-256 if empty
+	HashFunc string
+	// Pin pins the stored node recursively if set
+	Pin *PinOpts
+}
+
+// DagResolved is the block a path resolves to and the path left inside it
+type DagResolved struct {
+	Cid     cid.Cid
+	RemPath string
+}
+
+// RepoFsckOpts specifies how RepoFsck checks the blockstore
+type RepoFsckOpts struct {
+	// Concurrent is the number of blocks read at a time
+	Concurrent int
+	// Quarantine moves corrupt blocks out of the blockstore
+	Quarantine bool
+	// Restart checks from the first key instead of resuming an interrupted
+	// run
+	Restart bool
+}
+
+// FsckRef is a bad block found by RepoFsck, or a progress report if Cid is
+// undefined
+type FsckRef struct {
+	Cid cid.Cid
+	// Status is mismatch for corrupt blocks or error for unreadable ones
+	Status      string
+	Err         string
+	Quarantined bool
+	// Checked is the number of keys checked, counting the ones checked by
+	// the resumed runs
+	Checked int64
+	// Done is set on the report of a complete run
+	Done bool
+}
+
+// RepoGCOpts specifies how RepoGC and DagRm remove blocks
+type RepoGCOpts struct {
+	// DryRun lists the blocks which would be removed without removing them
+	DryRun bool
+	// Force lets RepoGC run with no pin at all, removing every block
+	Force bool
+}
+
+// GCRef is a block removed by RepoGC or DagRm, or the totals if Done is
+// set
+type GCRef struct {
+	Cid  cid.Cid
+	Size uint64
+	Err  string
+	// Done is set on the last ref of a complete run
+	Done    bool
+	Removed int64
+	// Freed is the total size of the removed blocks
+	Freed uint64
+	// Kept is the number of blocks of the dag kept by other pins, for DagRm
+	Kept int64
+}
+
+// RepoStatOpts specifies what RepoStat reports
+type RepoStatOpts struct {
+	// CountBlocks lists every block of the blockstore to count the blocks,
+	// their size and the usage of each server, which takes a while on large
+	// blockstores
+	CountBlocks bool
+}
+
+// RepoStats is the storage usage reported by RepoStat
+type RepoStats struct {
+	// Backend is badger, dscluster, remoteds or erasure
+	Backend  string
+	RepoPath string
+	// StorageDir is the directory of the badger blockstore, empty for the
+	// other backends
+	StorageDir string
+	// Counted tells whether the blocks were counted, Blocks, Bytes and the
+	// usage of the servers are only set then
+	Counted bool
+	Blocks  int64
+	// Bytes is the total size of the blocks
+	Bytes uint64
+	// DiskUsage is the space taken by the blocks as reported by the backend,
+	// 0 if it can not tell
+	DiskUsage uint64
+	// StorageMax and GCWatermark are the enforced limits in bytes, 0 if the
+	// storage is not limited
+	StorageMax  uint64
+	GCWatermark uint64
+	EnableGC    bool
+	// Servers breaks the usage down per server, when the backend can tell
+	// which server keeps a block
+	Servers []ServerStat
+}
+
+// ServerStat is the part of the blocks kept by a storage server
+type ServerStat struct {
+	ID     string
+	Addrs  []string
+	Blocks int64
+	Bytes  uint64
+}
+
+// PinOpts names and labels a pin
+type PinOpts struct {
+	// Name groups pins into named sets, it need not be unique
+	Name   string
+	Labels map[string]string
+}
+
+// PinInfo is a pin listed by PinLs
+type PinInfo struct {
+	Cid cid.Cid
+	// Mode is direct or recursive
+	Mode    string
+	Name    string
+	Labels  map[string]string
+	Created time.Time
+}
+
+// PinFilter selects pins, the empty fields match every pin
+type PinFilter struct {
+	Mode string
+	Name string
+	// Labels must all be set on the pin with the same values
+	Labels map[string]string
+}
+
+// PinVerifyRef tells whether the blocks kept by a pin are stored locally
+type PinVerifyRef struct {
+	Cid  cid.Cid
+	Mode string
+	Name string
+	// Complete is set if every block of the pin is stored locally
+	Complete bool
+	Blocks   int64
+	Missing  int64
+	Err      string
+}
+
+// FilestoreRef is the verify result of a block kept in the filestore
+type FilestoreRef struct {
+	Cid    cid.Cid
+	Path   string
+	Offset uint64
+	Size   uint64
+	// Status is one of ok, missing, changed or error
+	Status string
+	Err    string
+}
+
 type Common interface {
-	Add(context.Context, string) (chan PBar, error)
-	Add2(context.Context, string, int) (chan PBar, error)
-	Get(context.Context, cid.Cid, string) (chan PBar, error)
+	Add(context.Context, string, ImportOpts) (chan PBar, error)
+	Add2(context.Context, string, int, ImportOpts) (chan PBar, error)
+	AddDir(context.Context, string, ImportOpts) (chan PBar, error)
+	AddReader(context.Context, io.Reader, ImportOpts) (chan PBar, error)
+	AddTar(context.Context, io.Reader, ImportOpts) (chan PBar, error)
+	Get(context.Context, cid.Cid, string, GetOpts) (chan PBar, error)
+	GetTar(context.Context, cid.Cid) (chan CatChunk, error)
+	Cat(context.Context, cid.Cid, int64, int64) (chan CatChunk, error)
+	Ls(context.Context, string, bool) (chan LsEntry, error)
 }
 
 type Net interface {
@@ -34,16 +326,43 @@ type Net interface {
 
 type Dag interface {
 	DagStat(context.Context, cid.Cid, uint) (*format.NodeStat, error)
-	DagSync(context.Context, []cid.Cid, int) (chan string, error)
+	DagStatRecursive(context.Context, cid.Cid, DagStatOpts) (*DagStats, error)
+	DagCheck(context.Context, cid.Cid, int) (*DagCheckResult, error)
+	DagVerify(context.Context, cid.Cid, DagVerifyOpts) (chan DagVerifyRef, error)
+	DagSync(context.Context, []cid.Cid, int, *PinOpts) (chan string, error)
 	DagExport(context.Context, cid.Cid, string, bool, int, bool) (chan PBar, error)
 	DagHas(context.Context, cid.Cid) (bool, error)
-	DagImport(context.Context, string) (chan PBar, error)
+	DagImport(context.Context, string, *PinOpts) (chan PBar, error)
+	DagGet(context.Context, string) ([]byte, error)
+	DagPut(context.Context, []byte, DagPutOpts) (cid.Cid, error)
+	DagResolve(context.Context, string) (DagResolved, error)
+	DagRm(context.Context, cid.Cid, RepoGCOpts) (chan GCRef, error)
+}
+
+type Filestore interface {
+	FilestoreVerify(context.Context) (chan FilestoreRef, error)
+}
+
+type Repo interface {
+	RepoFsck(context.Context, RepoFsckOpts) (chan FsckRef, error)
+	RepoGC(context.Context, RepoGCOpts) (chan GCRef, error)
+	RepoStat(context.Context, RepoStatOpts) (*RepoStats, error)
+}
+
+type Pin interface {
+	PinAdd(context.Context, cid.Cid, bool, PinOpts) error
+	PinRm(context.Context, cid.Cid) error
+	PinLs(context.Context, PinFilter) ([]PinInfo, error)
+	PinVerify(context.Context, PinFilter) (chan PinVerifyRef, error)
 }
 
 type FullNode interface {
 	Common
 	Net
 	Dag
+	Filestore
+	Repo
+	Pin
 }
 
 type FullNodeClient struct {
@@ -55,13 +374,41 @@ type FullNodeClient struct {
 
 	ID        func(context.Context) (peer.ID, error)
 	DagStat   func(context.Context, cid.Cid, uint) (*format.NodeStat, error)
-	DagSync   func(context.Context, []cid.Cid, int) (chan string, error)
+	DagSync   func(context.Context, []cid.Cid, int, *PinOpts) (chan string, error)
 	DagExport func(context.Context, cid.Cid, string, bool, int, bool) (chan PBar, error)
-	DagImport func(context.Context, string) (chan PBar, error)
+	DagImport func(context.Context, string, *PinOpts) (chan PBar, error)
 	DagHas    func(context.Context, cid.Cid) (bool, error)
-	Add       func(context.Context, string) (chan PBar, error)
-	Add2      func(context.Context, string, int) (chan PBar, error)
-	Get       func(context.Context, cid.Cid, string) (chan PBar, error)
+	DagGet    func(context.Context, string) ([]byte, error)
+	DagPut    func(context.Context, []byte, DagPutOpts) (cid.Cid, error)
+
+	DagResolve func(context.Context, string) (DagResolved, error)
+
+	DagStatRecursive func(context.Context, cid.Cid, DagStatOpts) (*DagStats, error)
+	DagCheck         func(context.Context, cid.Cid, int) (*DagCheckResult, error)
+	DagVerify        func(context.Context, cid.Cid, DagVerifyOpts) (chan DagVerifyRef, error)
+
+	DagRm func(context.Context, cid.Cid, RepoGCOpts) (chan GCRef, error)
+
+	Add       func(context.Context, string, ImportOpts) (chan PBar, error)
+	Add2      func(context.Context, string, int, ImportOpts) (chan PBar, error)
+	AddDir    func(context.Context, string, ImportOpts) (chan PBar, error)
+	AddReader func(context.Context, io.Reader, ImportOpts) (chan PBar, error)
+	AddTar    func(context.Context, io.Reader, ImportOpts) (chan PBar, error)
+	Get       func(context.Context, cid.Cid, string, GetOpts) (chan PBar, error)
+	GetTar    func(context.Context, cid.Cid) (chan CatChunk, error)
+	Cat       func(context.Context, cid.Cid, int64, int64) (chan CatChunk, error)
+	Ls        func(context.Context, string, bool) (chan LsEntry, error)
+
+	FilestoreVerify func(context.Context) (chan FilestoreRef, error)
+
+	RepoFsck func(context.Context, RepoFsckOpts) (chan FsckRef, error)
+	RepoGC   func(context.Context, RepoGCOpts) (chan GCRef, error)
+	RepoStat func(context.Context, RepoStatOpts) (*RepoStats, error)
+
+	PinAdd    func(context.Context, cid.Cid, bool, PinOpts) error
+	PinRm     func(context.Context, cid.Cid) error
+	PinLs     func(context.Context, PinFilter) ([]PinInfo, error)
+	PinVerify func(context.Context, PinFilter) (chan PinVerifyRef, error)
 }
 
 type FullNodeClientApi struct {
@@ -96,30 +443,114 @@ func (a *FullNodeClientApi) DagStat(ctx context.Context, cid cid.Cid, timeout ui
 	return a.Emb.DagStat(ctx, cid, timeout)
 }
 
-func (a *FullNodeClientApi) DagSync(ctx context.Context, cids []cid.Cid, concur int) (chan string, error) {
-	return a.Emb.DagSync(ctx, cids, concur)
+func (a *FullNodeClientApi) DagStatRecursive(ctx context.Context, cid cid.Cid, opts DagStatOpts) (*DagStats, error) {
+	return a.Emb.DagStatRecursive(ctx, cid, opts)
+}
+
+func (a *FullNodeClientApi) DagCheck(ctx context.Context, cid cid.Cid, limit int) (*DagCheckResult, error) {
+	return a.Emb.DagCheck(ctx, cid, limit)
+}
+
+func (a *FullNodeClientApi) DagVerify(ctx context.Context, cid cid.Cid, opts DagVerifyOpts) (chan DagVerifyRef, error) {
+	return a.Emb.DagVerify(ctx, cid, opts)
+}
+
+func (a *FullNodeClientApi) DagSync(ctx context.Context, cids []cid.Cid, concur int, pin *PinOpts) (chan string, error) {
+	return a.Emb.DagSync(ctx, cids, concur, pin)
 }
 
 func (a *FullNodeClientApi) DagExport(ctx context.Context, cid cid.Cid, path string, pad bool, batchNum int, swarm bool) (chan PBar, error) {
 	return a.Emb.DagExport(ctx, cid, path, pad, batchNum, swarm)
 }
 
-func (a *FullNodeClientApi) DagImport(ctx context.Context, path string) (chan PBar, error) {
-	return a.Emb.DagImport(ctx, path)
+func (a *FullNodeClientApi) DagImport(ctx context.Context, path string, pin *PinOpts) (chan PBar, error) {
+	return a.Emb.DagImport(ctx, path, pin)
 }
 
 func (a *FullNodeClientApi) DagHas(ctx context.Context, cid cid.Cid) (bool, error) {
 	return a.Emb.DagHas(ctx, cid)
 }
 
-func (a *FullNodeClientApi) Add(ctx context.Context, path string) (chan PBar, error) {
-	return a.Emb.Add(ctx, path)
+func (a *FullNodeClientApi) DagGet(ctx context.Context, path string) ([]byte, error) {
+	return a.Emb.DagGet(ctx, path)
+}
+
+func (a *FullNodeClientApi) DagPut(ctx context.Context, data []byte, opts DagPutOpts) (cid.Cid, error) {
+	return a.Emb.DagPut(ctx, data, opts)
+}
+
+func (a *FullNodeClientApi) DagResolve(ctx context.Context, path string) (DagResolved, error) {
+	return a.Emb.DagResolve(ctx, path)
+}
+
+func (a *FullNodeClientApi) DagRm(ctx context.Context, cid cid.Cid, opts RepoGCOpts) (chan GCRef, error) {
+	return a.Emb.DagRm(ctx, cid, opts)
+}
+
+func (a *FullNodeClientApi) Add(ctx context.Context, path string, opts ImportOpts) (chan PBar, error) {
+	return a.Emb.Add(ctx, path, opts)
+}
+
+func (a *FullNodeClientApi) Add2(ctx context.Context, path string, br int, opts ImportOpts) (chan PBar, error) {
+	return a.Emb.Add2(ctx, path, br, opts)
+}
+
+func (a *FullNodeClientApi) AddDir(ctx context.Context, path string, opts ImportOpts) (chan PBar, error) {
+	return a.Emb.AddDir(ctx, path, opts)
+}
+
+func (a *FullNodeClientApi) AddReader(ctx context.Context, r io.Reader, opts ImportOpts) (chan PBar, error) {
+	return a.Emb.AddReader(ctx, r, opts)
+}
+
+func (a *FullNodeClientApi) AddTar(ctx context.Context, r io.Reader, opts ImportOpts) (chan PBar, error) {
+	return a.Emb.AddTar(ctx, r, opts)
+}
+
+func (a *FullNodeClientApi) Get(ctx context.Context, cid cid.Cid, path string, opts GetOpts) (chan PBar, error) {
+	return a.Emb.Get(ctx, cid, path, opts)
+}
+
+func (a *FullNodeClientApi) GetTar(ctx context.Context, cid cid.Cid) (chan CatChunk, error) {
+	return a.Emb.GetTar(ctx, cid)
+}
+
+func (a *FullNodeClientApi) Cat(ctx context.Context, cid cid.Cid, offset, length int64) (chan CatChunk, error) {
+	return a.Emb.Cat(ctx, cid, offset, length)
+}
+
+func (a *FullNodeClientApi) Ls(ctx context.Context, path string, chunks bool) (chan LsEntry, error) {
+	return a.Emb.Ls(ctx, path, chunks)
+}
+
+func (a *FullNodeClientApi) FilestoreVerify(ctx context.Context) (chan FilestoreRef, error) {
+	return a.Emb.FilestoreVerify(ctx)
+}
+
+func (a *FullNodeClientApi) RepoFsck(ctx context.Context, opts RepoFsckOpts) (chan FsckRef, error) {
+	return a.Emb.RepoFsck(ctx, opts)
+}
+
+func (a *FullNodeClientApi) RepoGC(ctx context.Context, opts RepoGCOpts) (chan GCRef, error) {
+	return a.Emb.RepoGC(ctx, opts)
+}
+
+func (a *FullNodeClientApi) RepoStat(ctx context.Context, opts RepoStatOpts) (*RepoStats, error) {
+	return a.Emb.RepoStat(ctx, opts)
+}
+
+func (a *FullNodeClientApi) PinAdd(ctx context.Context, cid cid.Cid, recursive bool, opts PinOpts) error {
+	return a.Emb.PinAdd(ctx, cid, recursive, opts)
+}
+
+func (a *FullNodeClientApi) PinRm(ctx context.Context, cid cid.Cid) error {
+	return a.Emb.PinRm(ctx, cid)
 }
 
-func (a *FullNodeClientApi) Add2(ctx context.Context, path string, br int) (chan PBar, error) {
-	return a.Emb.Add2(ctx, path, br)
+func (a *FullNodeClientApi) PinLs(ctx context.Context, filter PinFilter) ([]PinInfo, error) {
+	return a.Emb.PinLs(ctx, filter)
 }
 
-func (a *FullNodeClientApi) Get(ctx context.Context, cid cid.Cid, path string) (chan PBar, error) {
-	return a.Emb.Get(ctx, cid, path)
+func (a *FullNodeClientApi) PinVerify(ctx context.Context, filter PinFilter) (chan PinVerifyRef, error) {
+	return a.Emb.PinVerify(ctx, filter)
 }
@@ -1,20 +1,150 @@
 package cli
 
 import (
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 
+	fapi "github.com/filedrive-team/filejoy/api"
+	"github.com/filedrive-team/filejoy/node/impl"
 	"github.com/mitchellh/go-homedir"
 	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
 )
 
+// importFlags are the flags shared by commands which import files
+var importFlags = []cli.Flag{
+	&cli.IntFlag{
+		Name:  "cid-version",
+		Usage: "cid version, 0 or 1; defaults to 1 when the hash function is not sha2-256",
+		Value: 0,
+	},
+	&cli.StringFlag{
+		Name:  "hash",
+		Usage: "multihash function, e.g. sha2-256, blake2b-256",
+		Value: "sha2-256",
+	},
+	&cli.BoolFlag{
+		Name:  "raw-leaves",
+		Usage: "use raw blocks for leaf nodes; defaults to true when cid version is 1",
+	},
+	&cli.StringFlag{
+		Name:    "chunker",
+		Aliases: []string{"s"},
+		Usage:   "chunking algorithm, size-[bytes], rabin-[min]-[avg]-[max] or buzhash; defaults to size-1048576",
+	},
+	&cli.BoolFlag{
+		Name:  "trickle",
+		Usage: "use trickle dag layout instead of balanced",
+	},
+	&cli.IntFlag{
+		Name:  "max-links",
+		Usage: "max number of links per node; defaults to 1024 for balanced, 174 for trickle",
+	},
+	&cli.BoolFlag{
+		Name:    "only-hash",
+		Aliases: []string{"n"},
+		Usage:   "only compute the cid, do not store any block",
+	},
+	&cli.BoolFlag{
+		Name:  "nocopy",
+		Usage: "keep leaves as references to the source file in filestore; implies raw leaves",
+	},
+	&cli.BoolFlag{
+		Name:  "preserve-mode",
+		Usage: "record the file mode, restored by get",
+	},
+	&cli.BoolFlag{
+		Name:  "preserve-mtime",
+		Usage: "record the file modification time, restored by get",
+	},
+}
+
+func importOpts(cctx *cli.Context) fapi.ImportOpts {
+	opts := fapi.ImportOpts{
+		CidVersion: cctx.Int("cid-version"),
+		HashFunc:   cctx.String("hash"),
+		RawLeaves:  cctx.Bool("raw-leaves"),
+		Chunker:    cctx.String("chunker"),
+		MaxLinks:   cctx.Int("max-links"),
+		OnlyHash:   cctx.Bool("only-hash"),
+		NoCopy:     cctx.Bool("nocopy"),
+
+		PreserveMode:  cctx.Bool("preserve-mode"),
+		PreserveMtime: cctx.Bool("preserve-mtime"),
+	}
+	if cctx.Bool("trickle") {
+		opts.Layout = impl.LayoutTrickle
+	}
+	// follow go-ipfs, so that the same data gets the same cid
+	if opts.HashFunc != "sha2-256" && !cctx.IsSet("cid-version") {
+		opts.CidVersion = 1
+	}
+	if (opts.CidVersion > 0 || opts.NoCopy) && !cctx.IsSet("raw-leaves") {
+		opts.RawLeaves = true
+	}
+	return opts
+}
+
 var AddCmd = &cli.Command{
 	Name:  "add",
 	Usage: "add files",
-	Flags: []cli.Flag{},
+	Flags: append([]cli.Flag{
+		&cli.BoolFlag{
+			Name:    "recursive",
+			Aliases: []string{"r"},
+			Usage:   "add directory paths recursively",
+		},
+		&cli.BoolFlag{
+			Name:  "tar",
+			Usage: "import a tar or tar.gz archive as a directory, keeping file modes and mtimes",
+		},
+	}, append(importFlags, pinFlags...)...),
+	ArgsUsage: "<path>, or - to read from stdin",
 	Action: func(cctx *cli.Context) error {
 		ctx := ReqContext(cctx)
+		opts := importOpts(cctx)
+		pin, err := pinOpts(cctx)
+		if err != nil {
+			return err
+		}
+		opts.Pin = pin
+
+		// stream stdin or the archive to the daemon
+		if cctx.Args().First() == "-" || cctx.Bool("tar") {
+			if cctx.Bool("recursive") {
+				return xerrors.New("can not add a stream recursively")
+			}
+			var r io.Reader = os.Stdin
+			if p := cctx.Args().First(); p != "-" {
+				p, err := homedir.Expand(p)
+				if err != nil {
+					return err
+				}
+				f, err := os.Open(p)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				r = f
+			}
+			api, closer, err := GetAPI(cctx)
+			if err != nil {
+				return err
+			}
+			defer closer()
+			var pb chan fapi.PBar
+			if cctx.Bool("tar") {
+				pb, err = api.AddTar(ctx, r, opts)
+			} else {
+				pb, err = api.AddReader(ctx, r, opts)
+			}
+			if err != nil {
+				return err
+			}
+			return PrintProgress(pb)
+		}
 
 		p, err := homedir.Expand(cctx.Args().First())
 		if err != nil {
@@ -32,7 +162,12 @@ var AddCmd = &cli.Command{
 		}
 		defer closer()
 
-		pb, err := api.Add(ctx, p)
+		var pb chan fapi.PBar
+		if cctx.Bool("recursive") {
+			pb, err = api.AddDir(ctx, p, opts)
+		} else {
+			pb, err = api.Add(ctx, p, opts)
+		}
 		if err != nil {
 			return err
 		}
@@ -44,16 +179,22 @@ var AddCmd = &cli.Command{
 var Add2Cmd = &cli.Command{
 	Name:  "add2",
 	Usage: "add files",
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		&cli.IntFlag{
 			Name:    "batch-read",
 			Aliases: []string{"br"},
 			Usage:   "",
 			Value:   32,
 		},
-	},
+	}, append(importFlags, pinFlags...)...),
 	Action: func(cctx *cli.Context) error {
 		ctx := ReqContext(cctx)
+		opts := importOpts(cctx)
+		pin, err := pinOpts(cctx)
+		if err != nil {
+			return err
+		}
+		opts.Pin = pin
 
 		p, err := homedir.Expand(cctx.Args().First())
 		if err != nil {
@@ -71,7 +212,7 @@ var Add2Cmd = &cli.Command{
 		}
 		defer closer()
 
-		pb, err := api.Add2(ctx, p, cctx.Int("batch-read"))
+		pb, err := api.Add2(ctx, p, cctx.Int("batch-read"), opts)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"context"
+	"io"
+	"os"
+
+	fapi "github.com/filedrive-team/filejoy/api"
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+)
+
+var CatCmd = &cli.Command{
+	Name:      "cat",
+	Usage:     "write the content of a file to stdout",
+	ArgsUsage: "<cid>",
+	Flags: []cli.Flag{
+		&cli.Int64Flag{
+			Name:  "offset",
+			Usage: "byte offset to start reading from",
+		},
+		&cli.Int64Flag{
+			Name:    "length",
+			Aliases: []string{"l"},
+			Usage:   "max number of bytes to read, 0 reads to the end of the file",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := ReqContext(cctx)
+		if !cctx.Args().Present() {
+			return xerrors.New("usage: filejoy cat [--offset n] [--length n] <cid>")
+		}
+		cid, err := cid.Decode(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+		api, closer, err := GetAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		chunks, err := api.Cat(ctx, cid, cctx.Int64("offset"), cctx.Int64("length"))
+		if err != nil {
+			return err
+		}
+		return writeChunks(ctx, os.Stdout, chunks)
+	},
+}
+
+// writeChunks writes the data streamed in chunks to w, a stream closed
+// before its EOF chunk fails
+func writeChunks(ctx context.Context, w io.Writer, chunks chan fapi.CatChunk) error {
+	for chunk := range chunks {
+		if chunk.Err != "" {
+			return xerrors.New(chunk.Err)
+		}
+		if _, err := w.Write(chunk.Data); err != nil {
+			return err
+		}
+		if chunk.EOF {
+			return nil
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// the connection to the daemon was lost
+	return xerrors.New("stream interrupted, the output is incomplete")
+}
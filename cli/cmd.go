@@ -7,11 +7,13 @@ import (
 	"io"
 	"os"
 	"os/signal"
+	"path"
 	"path/filepath"
 	"strings"
 	"syscall"
 
 	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/filecoin-project/go-jsonrpc/httpio"
 	"github.com/filedrive-team/filejoy/api"
 	"github.com/filedrive-team/filejoy/node/config"
 	logging "github.com/ipfs/go-log/v2"
@@ -27,10 +29,15 @@ var Commands = []*cli.Command{
 	AddCmd,
 	Add2Cmd,
 	GetCmd,
+	CatCmd,
+	LsCmd,
 	SyncssCmd,
 	importDatasetCmd,
 	WithCategory("network", NetCmd),
 	WithCategory("dag", DagCmd),
+	WithCategory("filestore", FilestoreCmd),
+	WithCategory("repo", RepoCmd),
+	WithCategory("pin", PinCmd),
 }
 
 func WithCategory(cat string, cmd *cli.Command) *cli.Command {
@@ -63,7 +70,9 @@ func GetAPI(c *cli.Context) (api.FullNode, jsonrpc.ClientCloser, error) {
 	}
 	apiclient := &api.FullNodeClient{}
 
-	closer, err := jsonrpc.NewClient(ctx, fmt.Sprintf("ws://%s:%s%s", cfg.RPC.Host, cfg.RPC.Port, cfg.RPC.Root), "Filejoy", apiclient, nil)
+	// io.Reader params are pushed to the daemon over http
+	pushAddr := fmt.Sprintf("http://%s:%s%s", cfg.RPC.Host, cfg.RPC.Port, path.Join(cfg.RPC.Root, config.StreamPushPath))
+	closer, err := jsonrpc.NewMergeClient(ctx, fmt.Sprintf("ws://%s:%s%s", cfg.RPC.Host, cfg.RPC.Port, cfg.RPC.Root), "Filejoy", []interface{}{apiclient}, nil, httpio.ReaderParamEncoder(pushAddr))
 	if err != nil {
 		return nil, nil, err
 	}
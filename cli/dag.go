@@ -15,8 +15,10 @@ import (
 	"time"
 
 	"github.com/filecoin-project/go-padreader"
+	fapi "github.com/filedrive-team/filejoy/api"
 	"github.com/filedrive-team/filejoy/node"
 	ncfg "github.com/filedrive-team/filejoy/node/config"
+	"github.com/filedrive-team/filejoy/node/impl"
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
 	bstore "github.com/ipfs/go-ipfs-blockstore"
@@ -36,15 +38,87 @@ var DagCmd = &cli.Command{
 	Usage: "Manage dag",
 	Subcommands: []*cli.Command{
 		DagStat,
+		DagGet,
+		DagPut,
+		DagResolve,
 		DagSync,
 		DagExport,
 		DagImport,
 		DagImport2,
 		DagHas,
+		DagCheck,
+		DagVerify,
+		DagRm,
 		DagGenPieces,
 	},
 }
 
+var DagRm = &cli.Command{
+	Name:      "rm",
+	Usage:     "unpin dag and remove its blocks, except the blocks kept by other pins",
+	ArgsUsage: "<cid>",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "only list the blocks which would be removed",
+		},
+		&cli.BoolFlag{
+			Name:    "quiet",
+			Aliases: []string{"q"},
+			Usage:   "only print the totals",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := ReqContext(cctx)
+
+		cid, err := cid.Decode(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		api, closer, err := GetAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		refs, err := api.DagRm(ctx, cid, fapi.RepoGCOpts{
+			DryRun: cctx.Bool("dry-run"),
+		})
+		if err != nil {
+			return err
+		}
+		verb := "removed"
+		if cctx.Bool("dry-run") {
+			verb = "would remove"
+		}
+		var failed int
+		var done fapi.GCRef
+		for ref := range refs {
+			switch {
+			case ref.Done:
+				done = ref
+			case ref.Err != "":
+				failed++
+				fmt.Printf("failed to remove %s: %s\n", ref.Cid, ref.Err)
+			case !cctx.Bool("quiet"):
+				fmt.Printf("%s %s %d\n", verb, ref.Cid, ref.Size)
+			}
+		}
+		if !done.Done {
+			return xerrors.New("dag rm interrupted")
+		}
+		if done.Err != "" {
+			return xerrors.New(done.Err)
+		}
+		fmt.Printf("%s %d blocks, %d bytes; kept %d blocks of other pins\n", verb, done.Removed, done.Freed, done.Kept)
+		if failed > 0 {
+			return xerrors.Errorf("failed to remove %d blocks", failed)
+		}
+		return nil
+	},
+}
+
 var DagHas = &cli.Command{
 	Name:  "has",
 	Usage: "check if local block store has dag",
@@ -77,15 +151,150 @@ var DagHas = &cli.Command{
 	},
 }
 
+var DagCheck = &cli.Command{
+	Name:      "check",
+	Usage:     "check if local block store has every block of dag",
+	ArgsUsage: "<cid>",
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:    "limit",
+			Aliases: []string{"n"},
+			Usage:   "max number of missing cids to list",
+			Value:   10,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := ReqContext(cctx)
+
+		cid, err := cid.Decode(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		api, closer, err := GetAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		res, err := api.DagCheck(ctx, cid, cctx.Int("limit"))
+		if err != nil {
+			return err
+		}
+		if res.Complete {
+			fmt.Printf("complete %s: %d blocks\n", cid, res.Blocks)
+			return nil
+		}
+		fmt.Printf("incomplete %s: %d blocks, %d missing\n", cid, res.Blocks, res.Missing)
+		for _, c := range res.MissingCids {
+			fmt.Printf("missing %s\n", c)
+		}
+		return xerrors.Errorf("%d blocks of %s are missing", res.Missing, cid)
+	},
+}
+
+var DagVerify = &cli.Command{
+	Name:      "verify",
+	Usage:     "rehash every local block of dag",
+	ArgsUsage: "<cid>",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "repair",
+			Usage: "fetch mismatched and undecodable blocks again from the network",
+		},
+		&cli.BoolFlag{
+			Name:  "problems",
+			Usage: "only print bad blocks",
+		},
+		&cli.IntFlag{
+			Name:    "concurrent",
+			Aliases: []string{"c"},
+			Usage:   "number of blocks verified at a time",
+			Value:   8,
+		},
+		&cli.UintFlag{
+			Name:  "timeout",
+			Usage: "seconds to wait for each repaired block",
+			Value: 60,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := ReqContext(cctx)
+
+		cid, err := cid.Decode(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		api, closer, err := GetAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		refs, err := api.DagVerify(ctx, cid, fapi.DagVerifyOpts{
+			Concurrent: cctx.Int("concurrent"),
+			Repair:     cctx.Bool("repair"),
+			Timeout:    cctx.Uint("timeout"),
+		})
+		if err != nil {
+			return err
+		}
+		var total, bad, repaired int
+		for ref := range refs {
+			total++
+			switch {
+			case ref.Repaired:
+				repaired++
+			case ref.Status != impl.BlockOK:
+				bad++
+			case cctx.Bool("problems"):
+				continue
+			}
+			status := ref.Status
+			if ref.Repaired {
+				status += " repaired"
+			}
+			fmt.Printf("%-12s %s\n", status, ref.Cid)
+			if ref.Err != "" {
+				fmt.Printf("             %s\n", ref.Err)
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fmt.Printf("%d blocks verified, %d repaired, %d problems\n", total, repaired, bad)
+		if bad > 0 {
+			return xerrors.Errorf("%d blocks of %s are bad", bad, cid)
+		}
+		return nil
+	},
+}
+
 var DagStat = &cli.Command{
 	Name:  "stat",
 	Usage: "print dag info",
 	Flags: []cli.Flag{
 		&cli.IntFlag{
 			Name:  "timeout",
-			Usage: "",
+			Usage: "seconds to wait for the root, or for each block with --recursive",
 			Value: 15,
 		},
+		&cli.BoolFlag{
+			Name:    "recursive",
+			Aliases: []string{"r"},
+			Usage:   "walk the whole dag and print cumulative stats",
+		},
+		&cli.IntFlag{
+			Name:    "concurrent",
+			Aliases: []string{"c"},
+			Usage:   "number of blocks loaded at a time with --recursive",
+			Value:   32,
+		},
+		&cli.BoolFlag{
+			Name:  "offline",
+			Usage: "only walk local blocks with --recursive, others are counted as missing",
+		},
 	},
 	Action: func(cctx *cli.Context) error {
 		ctx := ReqContext(cctx)
@@ -101,6 +310,23 @@ var DagStat = &cli.Command{
 		}
 		defer closer()
 
+		if cctx.Bool("recursive") {
+			stats, err := api.DagStatRecursive(ctx, cid, fapi.DagStatOpts{
+				Concurrent: cctx.Int("concurrent"),
+				Offline:    cctx.Bool("offline"),
+				Timeout:    cctx.Uint("timeout"),
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Blocks:    %d\n", stats.Blocks)
+			fmt.Printf("Bytes:     %d\n", stats.Bytes)
+			fmt.Printf("FileSize:  %d\n", stats.FileSize)
+			fmt.Printf("MaxDepth:  %d\n", stats.MaxDepth)
+			fmt.Printf("Missing:   %d\n", stats.Missing)
+			return nil
+		}
+
 		stat, err := api.DagStat(ctx, cid, cctx.Uint("timeout"))
 		if err != nil {
 			return err
@@ -111,10 +337,124 @@ var DagStat = &cli.Command{
 	},
 }
 
+var DagGet = &cli.Command{
+	Name:      "get",
+	Usage:     "print an ipld node as dag-json",
+	ArgsUsage: "<cid>[/path]",
+	Action: func(cctx *cli.Context) error {
+		ctx := ReqContext(cctx)
+		if !cctx.Args().Present() {
+			return xerrors.New("usage: filejoy dag get <cid>[/path]")
+		}
+
+		api, closer, err := GetAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		data, err := api.DagGet(ctx, cctx.Args().First())
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	},
+}
+
+var DagPut = &cli.Command{
+	Name:      "put",
+	Usage:     "store an ipld node read from a file or stdin",
+	ArgsUsage: "[path], stdin is read if omitted",
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:  "input-codec",
+			Usage: "codec of the input, dag-json, dag-cbor, dag-pb or raw",
+			Value: "dag-json",
+		},
+		&cli.StringFlag{
+			Name:  "store-codec",
+			Usage: "codec of the stored block, dag-cbor, dag-json, dag-pb or raw",
+			Value: "dag-cbor",
+		},
+		&cli.StringFlag{
+			Name:  "hash",
+			Usage: "multihash function, e.g. sha2-256, blake2b-256",
+		},
+	}, pinFlags...),
+	Action: func(cctx *cli.Context) error {
+		ctx := ReqContext(cctx)
+		pin, err := pinOpts(cctx)
+		if err != nil {
+			return err
+		}
+
+		var data []byte
+		if p := cctx.Args().First(); p != "" && p != "-" {
+			if p, err = homedir.Expand(p); err != nil {
+				return err
+			}
+			data, err = ioutil.ReadFile(p)
+		} else {
+			data, err = ioutil.ReadAll(os.Stdin)
+		}
+		if err != nil {
+			return err
+		}
+
+		api, closer, err := GetAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		c, err := api.DagPut(ctx, data, fapi.DagPutOpts{
+			InputCodec: cctx.String("input-codec"),
+			StoreCodec: cctx.String("store-codec"),
+			HashFunc:   cctx.String("hash"),
+			Pin:        pin,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println(c)
+		return nil
+	},
+}
+
+var DagResolve = &cli.Command{
+	Name:      "resolve",
+	Usage:     "resolve an ipld path to a block and the path left inside it",
+	ArgsUsage: "<cid>[/path]",
+	Action: func(cctx *cli.Context) error {
+		ctx := ReqContext(cctx)
+		if !cctx.Args().Present() {
+			return xerrors.New("usage: filejoy dag resolve <cid>[/path]")
+		}
+
+		api, closer, err := GetAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		res, err := api.DagResolve(ctx, cctx.Args().First())
+		if err != nil {
+			return err
+		}
+		if res.RemPath == "" {
+			fmt.Println(res.Cid)
+		} else {
+			fmt.Printf("%s/%s\n", res.Cid, res.RemPath)
+		}
+		return nil
+	},
+}
+
 var DagSync = &cli.Command{
 	Name:  "sync",
 	Usage: "sync dags",
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		&cli.IntFlag{
 			Name:    "concurrent",
 			Aliases: []string{"c"},
@@ -125,11 +465,15 @@ var DagSync = &cli.Command{
 			Name:  "f",
 			Usage: "",
 		},
-	},
+	}, pinFlags...),
 	Action: func(cctx *cli.Context) error {
 		ctx := ReqContext(cctx)
 		args := cctx.Args().Slice()
 		cidsFilePath := cctx.String("f")
+		pin, err := pinOpts(cctx)
+		if err != nil {
+			return err
+		}
 
 		if cidsFilePath != "" {
 			if bs, err := ioutil.ReadFile(cidsFilePath); err == nil {
@@ -159,7 +503,7 @@ var DagSync = &cli.Command{
 		}
 		defer closer()
 		for _, item := range cids {
-			msgch, err := api.DagSync(ctx, []cid.Cid{item}, cctx.Int("concurrent"))
+			msgch, err := api.DagSync(ctx, []cid.Cid{item}, cctx.Int("concurrent"), pin)
 			if err != nil {
 				return err
 			}
@@ -175,7 +519,7 @@ var DagSync = &cli.Command{
 var DagImport = &cli.Command{
 	Name:  "import",
 	Usage: "import car file",
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		&cli.StringFlag{
 			Name:  "f",
 			Usage: "",
@@ -189,13 +533,17 @@ var DagImport = &cli.Command{
 			Value: false,
 			Usage: "delete the car file been imported",
 		},
-	},
+	}, pinFlags...),
 	Action: func(cctx *cli.Context) error {
 		ctx := ReqContext(cctx)
 		args := cctx.Args().Slice()
 		cidsFilePath := cctx.String("f")
 		filestorePath := cctx.String("filestore")
 		deleteSource := cctx.Bool("delete-source")
+		pin, err := pinOpts(cctx)
+		if err != nil {
+			return err
+		}
 
 		curdir, err := os.Getwd()
 		if err != nil {
@@ -233,7 +581,7 @@ var DagImport = &cli.Command{
 				carPath = filepath.Join(curdir, carPath)
 			}
 			log.Infof("start to import %s", carPath)
-			pb, err := api.DagImport(ctx, carPath)
+			pb, err := api.DagImport(ctx, carPath, pin)
 			if err != nil {
 				log.Error(err)
 				continue
@@ -501,6 +849,15 @@ var DagGenPieces = &cli.Command{
 		if err != nil {
 			return err
 		}
+		// leaves added with --nocopy are only in the filestore
+		if cfg.EnableFilestore {
+			fstore, fsCloser, err := node.OpenFilestore(cfg, repoPath, blkst)
+			if err != nil {
+				return err
+			}
+			defer fsCloser()
+			blkst = fstore
+		}
 
 		args := cctx.Args().Slice()
 		if len(args) < 2 {
@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/filedrive-team/filejoy/node/filestore"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+)
+
+var FilestoreCmd = &cli.Command{
+	Name:  "filestore",
+	Usage: "Manage the blocks added with --nocopy",
+	Subcommands: []*cli.Command{
+		FilestoreVerify,
+	},
+}
+
+var FilestoreVerify = &cli.Command{
+	Name:  "verify",
+	Usage: "Verify the source files of the blocks in filestore",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "problems",
+			Usage: "only print missing or changed blocks",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := GetAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := ReqContext(cctx)
+		refs, err := api.FilestoreVerify(ctx)
+		if err != nil {
+			return err
+		}
+		var total, bad int
+		for ref := range refs {
+			total++
+			if ref.Status != filestore.StatusOK {
+				bad++
+			} else if cctx.Bool("problems") {
+				continue
+			}
+			fmt.Printf("%-8s %s %d %s %d\n", ref.Status, ref.Cid, ref.Size, ref.Path, ref.Offset)
+			if ref.Err != "" && ref.Status == filestore.StatusError {
+				fmt.Printf("         %s\n", ref.Err)
+			}
+		}
+		fmt.Printf("%d blocks verified, %d problems\n", total, bad)
+		if bad > 0 {
+			return xerrors.Errorf("%d blocks in filestore can not be read", bad)
+		}
+		return nil
+	},
+}
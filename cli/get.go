@@ -1,10 +1,12 @@
 package cli
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"strings"
 
+	fapi "github.com/filedrive-team/filejoy/api"
 	"github.com/ipfs/go-cid"
 	"github.com/mitchellh/go-homedir"
 	"github.com/urfave/cli/v2"
@@ -12,8 +14,21 @@ import (
 
 var GetCmd = &cli.Command{
 	Name:  "get",
-	Usage: "get file by cid",
-	Flags: []cli.Flag{},
+	Usage: "get file or directory by cid",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "tar",
+			Usage: "write a tar archive to path instead of the files",
+		},
+		&cli.BoolFlag{
+			Name:  "resume",
+			Usage: "continue partial files from their length",
+		},
+		&cli.BoolFlag{
+			Name:  "verify",
+			Usage: "re-import the written files and check their cids",
+		},
+	},
 	Action: func(cctx *cli.Context) error {
 		ctx := ReqContext(cctx)
 		args := cctx.Args().Slice()
@@ -40,7 +55,13 @@ var GetCmd = &cli.Command{
 		}
 		defer closer()
 
-		pb, err := api.Get(ctx, cid, p)
+		if cctx.Bool("tar") {
+			return getTar(ctx, api, cid, p)
+		}
+		pb, err := api.Get(ctx, cid, p, fapi.GetOpts{
+			Resume: cctx.Bool("resume"),
+			Verify: cctx.Bool("verify"),
+		})
 		if err != nil {
 			return err
 		}
@@ -48,3 +69,27 @@ var GetCmd = &cli.Command{
 		return PrintProgress(pb)
 	},
 }
+
+// getTar writes the tar archive of c streamed by the daemon to p, an
+// incomplete archive is removed
+func getTar(ctx context.Context, api fapi.FullNode, c cid.Cid, p string) error {
+	chunks, err := api.GetTar(ctx, c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	err = writeChunks(ctx, f, chunks)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(p)
+	}
+	return err
+}
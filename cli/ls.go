@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+)
+
+var LsCmd = &cli.Command{
+	Name:      "ls",
+	Usage:     "list directory entries or file chunks",
+	ArgsUsage: "<cid>[/path]",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "chunks",
+			Usage: "list the chunk links of a file with their offsets",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := ReqContext(cctx)
+		if !cctx.Args().Present() {
+			return xerrors.New("usage: filejoy ls [--chunks] <cid>[/path]")
+		}
+		api, closer, err := GetAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		chunks := cctx.Bool("chunks")
+		ents, err := api.Ls(ctx, cctx.Args().First(), chunks)
+		if err != nil {
+			return err
+		}
+		for ent := range ents {
+			if ent.Err != "" {
+				return xerrors.New(ent.Err)
+			}
+			if ent.Name == "" && chunks {
+				fmt.Printf("%s %-4s %12d @ %d\n", ent.Cid, ent.Type, ent.Size, ent.Offset)
+				continue
+			}
+			fmt.Printf("%s %-7s %12d %s\n", ent.Cid, ent.Type, ent.Size, ent.Name)
+		}
+		return ctx.Err()
+	},
+}
@@ -0,0 +1,305 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	fapi "github.com/filedrive-team/filejoy/api"
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+)
+
+// pinFlags are the flags of commands which can pin the roots they import
+var pinFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:  "pin",
+		Usage: "pin the imported roots recursively, unpinned blocks are removed by repo gc",
+		Value: true,
+	},
+	&cli.StringFlag{
+		Name:  "pin-name",
+		Usage: "name of the pin set",
+	},
+	&cli.StringSliceFlag{
+		Name:  "pin-label",
+		Usage: "label of the pins as key=value, can be repeated",
+	},
+}
+
+// pinOpts returns the pin options set by pinFlags, nil if nothing is to be
+// pinned. The imported roots are pinned unless --pin=false
+func pinOpts(cctx *cli.Context) (*fapi.PinOpts, error) {
+	if !cctx.Bool("pin") {
+		if cctx.IsSet("pin-name") || cctx.IsSet("pin-label") {
+			return nil, xerrors.New("--pin-name and --pin-label can not be used with --pin=false")
+		}
+		return nil, nil
+	}
+	labels, err := parseLabels(cctx.StringSlice("pin-label"))
+	if err != nil {
+		return nil, err
+	}
+	return &fapi.PinOpts{
+		Name:   cctx.String("pin-name"),
+		Labels: labels,
+	}, nil
+}
+
+// parseLabels parses key=value labels
+func parseLabels(ss []string) (map[string]string, error) {
+	if len(ss) == 0 {
+		return nil, nil
+	}
+	labels := make(map[string]string, len(ss))
+	for _, s := range ss {
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, xerrors.Errorf("invalid label %q, expected key=value", s)
+		}
+		labels[kv[0]] = kv[1]
+	}
+	return labels, nil
+}
+
+// pinFilterFlags select pins by mode, name and labels
+var pinFilterFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "type",
+		Usage: "only pins of type direct or recursive",
+	},
+	&cli.StringFlag{
+		Name:  "name",
+		Usage: "only pins of the named set",
+	},
+	&cli.StringSliceFlag{
+		Name:  "label",
+		Usage: "only pins with the label key=value, can be repeated",
+	},
+}
+
+func pinFilter(cctx *cli.Context) (fapi.PinFilter, error) {
+	labels, err := parseLabels(cctx.StringSlice("label"))
+	if err != nil {
+		return fapi.PinFilter{}, err
+	}
+	return fapi.PinFilter{
+		Mode:   cctx.String("type"),
+		Name:   cctx.String("name"),
+		Labels: labels,
+	}, nil
+}
+
+func formatLabels(labels map[string]string) string {
+	kvs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		kvs = append(kvs, k+"="+v)
+	}
+	sort.Strings(kvs)
+	return strings.Join(kvs, ",")
+}
+
+var PinCmd = &cli.Command{
+	Name:  "pin",
+	Usage: "Manage the dags kept in the blockstore",
+	Subcommands: []*cli.Command{
+		PinAdd,
+		PinRm,
+		PinLs,
+		PinVerify,
+	},
+}
+
+var PinAdd = &cli.Command{
+	Name:      "add",
+	Usage:     "pin dags, fetching their missing blocks",
+	ArgsUsage: "<cid>...",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "direct",
+			Usage: "only pin the root blocks instead of the whole dags",
+		},
+		&cli.StringFlag{
+			Name:  "name",
+			Usage: "name of the pin set",
+		},
+		&cli.StringSliceFlag{
+			Name:  "label",
+			Usage: "label of the pins as key=value, can be repeated",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := ReqContext(cctx)
+		if cctx.NArg() == 0 {
+			return xerrors.New("no cid to pin")
+		}
+		labels, err := parseLabels(cctx.StringSlice("label"))
+		if err != nil {
+			return err
+		}
+		var cids []cid.Cid
+		for _, s := range cctx.Args().Slice() {
+			c, err := cid.Decode(s)
+			if err != nil {
+				return err
+			}
+			cids = append(cids, c)
+		}
+
+		api, closer, err := GetAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		opts := fapi.PinOpts{
+			Name:   cctx.String("name"),
+			Labels: labels,
+		}
+		for _, c := range cids {
+			if err := api.PinAdd(ctx, c, !cctx.Bool("direct"), opts); err != nil {
+				return xerrors.Errorf("pin %s: %w", c, err)
+			}
+			fmt.Printf("pinned %s\n", c)
+		}
+		return nil
+	},
+}
+
+var PinRm = &cli.Command{
+	Name:      "rm",
+	Usage:     "unpin dags, their blocks are kept until collected",
+	ArgsUsage: "[<cid>...]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "name",
+			Usage: "unpin every pin of the named set",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := ReqContext(cctx)
+		var cids []cid.Cid
+		for _, s := range cctx.Args().Slice() {
+			c, err := cid.Decode(s)
+			if err != nil {
+				return err
+			}
+			cids = append(cids, c)
+		}
+		if len(cids) == 0 && cctx.String("name") == "" {
+			return xerrors.New("no cid or pin set name to unpin")
+		}
+
+		api, closer, err := GetAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		if name := cctx.String("name"); name != "" {
+			pins, err := api.PinLs(ctx, fapi.PinFilter{Name: name})
+			if err != nil {
+				return err
+			}
+			for _, p := range pins {
+				cids = append(cids, p.Cid)
+			}
+		}
+		for _, c := range cids {
+			if err := api.PinRm(ctx, c); err != nil {
+				return xerrors.Errorf("unpin %s: %w", c, err)
+			}
+			fmt.Printf("unpinned %s\n", c)
+		}
+		return nil
+	},
+}
+
+var PinLs = &cli.Command{
+	Name:  "ls",
+	Usage: "list pins",
+	Flags: append([]cli.Flag{
+		&cli.BoolFlag{
+			Name:    "quiet",
+			Aliases: []string{"q"},
+			Usage:   "only print the cids",
+		},
+	}, pinFilterFlags...),
+	Action: func(cctx *cli.Context) error {
+		ctx := ReqContext(cctx)
+		filter, err := pinFilter(cctx)
+		if err != nil {
+			return err
+		}
+
+		api, closer, err := GetAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		pins, err := api.PinLs(ctx, filter)
+		if err != nil {
+			return err
+		}
+		for _, p := range pins {
+			if cctx.Bool("quiet") {
+				fmt.Println(p.Cid)
+				continue
+			}
+			fmt.Printf("%s %s %s %s %s\n", p.Cid, p.Mode, p.Created.Format("2006-01-02T15:04:05"), p.Name, formatLabels(p.Labels))
+		}
+		return nil
+	},
+}
+
+var PinVerify = &cli.Command{
+	Name:  "verify",
+	Usage: "check that every block of the pins is stored locally",
+	Flags: append([]cli.Flag{
+		&cli.BoolFlag{
+			Name:  "problems",
+			Usage: "only print incomplete pins",
+		},
+	}, pinFilterFlags...),
+	Action: func(cctx *cli.Context) error {
+		ctx := ReqContext(cctx)
+		filter, err := pinFilter(cctx)
+		if err != nil {
+			return err
+		}
+
+		api, closer, err := GetAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		refs, err := api.PinVerify(ctx, filter)
+		if err != nil {
+			return err
+		}
+		var total, bad int
+		for ref := range refs {
+			total++
+			switch {
+			case ref.Err != "":
+				bad++
+				fmt.Printf("error %s: %s\n", ref.Cid, ref.Err)
+			case !ref.Complete:
+				bad++
+				fmt.Printf("incomplete %s: %d blocks, %d missing\n", ref.Cid, ref.Blocks, ref.Missing)
+			case !cctx.Bool("problems"):
+				fmt.Printf("complete %s: %d blocks\n", ref.Cid, ref.Blocks)
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if bad > 0 {
+			return xerrors.Errorf("%d of %d pins are incomplete", bad, total)
+		}
+		return nil
+	},
+}
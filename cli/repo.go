@@ -0,0 +1,201 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	fapi "github.com/filedrive-team/filejoy/api"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+)
+
+var RepoCmd = &cli.Command{
+	Name:  "repo",
+	Usage: "Manage the blockstore",
+	Subcommands: []*cli.Command{
+		RepoFsck,
+		RepoGC,
+		RepoStat,
+	},
+}
+
+var RepoFsck = &cli.Command{
+	Name:  "fsck",
+	Usage: "Rehash every block in the blockstore, an interrupted run is resumed",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "quarantine",
+			Usage: "move corrupt blocks out of the blockstore",
+		},
+		&cli.BoolFlag{
+			Name:  "restart",
+			Usage: "check from the first block instead of resuming",
+		},
+		&cli.IntFlag{
+			Name:    "concurrent",
+			Aliases: []string{"c"},
+			Usage:   "number of blocks read at a time",
+			Value:   8,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := GetAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := ReqContext(cctx)
+		refs, err := api.RepoFsck(ctx, fapi.RepoFsckOpts{
+			Concurrent: cctx.Int("concurrent"),
+			Quarantine: cctx.Bool("quarantine"),
+			Restart:    cctx.Bool("restart"),
+		})
+		if err != nil {
+			return err
+		}
+		var bad, quarantined int
+		var done fapi.FsckRef
+		for ref := range refs {
+			switch {
+			case ref.Done:
+				done = ref
+			case !ref.Cid.Defined():
+				fmt.Printf("%d blocks checked\n", ref.Checked)
+			default:
+				bad++
+				status := ref.Status
+				if ref.Quarantined {
+					quarantined++
+					status += " quarantined"
+				}
+				fmt.Printf("%-22s %s\n", status, ref.Cid)
+				fmt.Printf("                       %s\n", ref.Err)
+			}
+		}
+		if !done.Done {
+			return xerrors.New("fsck interrupted, run it again to resume")
+		}
+		if done.Err != "" {
+			return xerrors.New(done.Err)
+		}
+		fmt.Printf("%d blocks checked, %d problems, %d quarantined\n", done.Checked, bad, quarantined)
+		if bad > quarantined {
+			return xerrors.Errorf("%d blocks in blockstore are bad", bad-quarantined)
+		}
+		return nil
+	},
+}
+
+var RepoGC = &cli.Command{
+	Name:  "gc",
+	Usage: "Remove every block which is not pinned nor reachable from a recursive pin",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "only list the blocks which would be removed",
+		},
+		&cli.BoolFlag{
+			Name:    "quiet",
+			Aliases: []string{"q"},
+			Usage:   "only print the totals",
+		},
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "run even if nothing is pinned, removing every block",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := GetAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := ReqContext(cctx)
+		refs, err := api.RepoGC(ctx, fapi.RepoGCOpts{
+			DryRun: cctx.Bool("dry-run"),
+			Force:  cctx.Bool("force"),
+		})
+		if err != nil {
+			return err
+		}
+		verb := "removed"
+		if cctx.Bool("dry-run") {
+			verb = "would remove"
+		}
+		var failed int
+		var done fapi.GCRef
+		for ref := range refs {
+			switch {
+			case ref.Done:
+				done = ref
+			case ref.Err != "":
+				failed++
+				fmt.Printf("failed to remove %s: %s\n", ref.Cid, ref.Err)
+			case !cctx.Bool("quiet"):
+				fmt.Printf("%s %s %d\n", verb, ref.Cid, ref.Size)
+			}
+		}
+		if !done.Done {
+			return xerrors.New("gc interrupted")
+		}
+		fmt.Printf("%s %d blocks, %d bytes\n", verb, done.Removed, done.Freed)
+		if done.Err != "" {
+			return xerrors.New(done.Err)
+		}
+		if failed > 0 {
+			return xerrors.Errorf("failed to remove %d blocks", failed)
+		}
+		return nil
+	},
+}
+
+var RepoStat = &cli.Command{
+	Name:  "stat",
+	Usage: "Report the blocks kept by the storage backend and its limits",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "count-blocks",
+			Usage: "count the blocks and their size, listing the whole blockstore",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := GetAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+		ctx := ReqContext(cctx)
+		st, err := api.RepoStat(ctx, fapi.RepoStatOpts{
+			CountBlocks: cctx.Bool("count-blocks"),
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Backend:     %s\n", st.Backend)
+		fmt.Printf("RepoPath:    %s\n", st.RepoPath)
+		if st.StorageDir != "" {
+			fmt.Printf("StorageDir:  %s\n", st.StorageDir)
+		}
+		if st.DiskUsage > 0 {
+			fmt.Printf("DiskUsage:   %d (%s)\n", st.DiskUsage, humanize.Bytes(st.DiskUsage))
+		}
+		if st.Counted {
+			fmt.Printf("Blocks:      %d\n", st.Blocks)
+			fmt.Printf("Bytes:       %d (%s)\n", st.Bytes, humanize.Bytes(st.Bytes))
+		} else {
+			fmt.Println("Blocks:      not counted, see --count-blocks")
+		}
+		if st.StorageMax > 0 {
+			fmt.Printf("StorageMax:  %d (%s)\n", st.StorageMax, humanize.Bytes(st.StorageMax))
+			fmt.Printf("GCWatermark: %d (%s)\n", st.GCWatermark, humanize.Bytes(st.GCWatermark))
+			fmt.Printf("EnableGC:    %t\n", st.EnableGC)
+		} else {
+			fmt.Println("StorageMax:  none")
+		}
+		for _, s := range st.Servers {
+			fmt.Printf("Server %s %s: %d blocks, %d bytes (%s)\n", s.ID, strings.Join(s.Addrs, ","), s.Blocks, s.Bytes, humanize.Bytes(s.Bytes))
+		}
+		return nil
+	},
+}
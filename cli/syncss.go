@@ -12,15 +12,18 @@ import (
 
 	"github.com/filedag-project/trans"
 	"github.com/filedrive-team/filehelper"
-	"github.com/filedrive-team/filehelper/dataset"
+	fapi "github.com/filedrive-team/filejoy/api"
+	"github.com/filedrive-team/filejoy/node"
 	ncfg "github.com/filedrive-team/filejoy/node/config"
+	"github.com/filedrive-team/filejoy/node/filestore"
+	"github.com/filedrive-team/filejoy/node/impl"
+	"github.com/filedrive-team/filejoy/node/pinner"
 	"github.com/filedrive-team/go-ds-cluster/clusterclient"
 	dsccfg "github.com/filedrive-team/go-ds-cluster/config"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
 	dsmount "github.com/ipfs/go-datastore/mount"
 	bstore "github.com/ipfs/go-ipfs-blockstore"
-	"github.com/ipfs/go-merkledag"
 	"github.com/mitchellh/go-homedir"
 	"github.com/pierrec/lz4/v4"
 	"github.com/urfave/cli/v2"
@@ -30,7 +33,7 @@ import (
 var SyncssCmd = &cli.Command{
 	Name:  "syncss",
 	Usage: "sync dataset with snapshot",
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		&cli.BoolFlag{
 			Name:    "only-dag",
 			Aliases: []string{"od"},
@@ -59,9 +62,21 @@ var SyncssCmd = &cli.Command{
 			Value: 0, // 3TiB 3298534883328
 			Usage: "split snapshot file into slice according to sssize",
 		},
-	},
+		&cli.BoolFlag{
+			Name:  "resume",
+			Usage: "continue partial files from their length",
+		},
+		&cli.BoolFlag{
+			Name:  "verify",
+			Usage: "re-import the synced files and check their cids",
+		},
+	}, pinFlags...),
 	Action: func(cctx *cli.Context) error {
 		ctx := ReqContext(cctx)
+		pin, err := pinOpts(cctx)
+		if err != nil {
+			return err
+		}
 		sssize := cctx.Int64("sssize")
 		onlyDag := cctx.Bool("only-dag")
 		onlyCheck := cctx.Bool("only-check")
@@ -70,7 +85,6 @@ var SyncssCmd = &cli.Command{
 			log.Info("usage: filejoy syncss [snapshot-cid] [target-path]")
 			return nil
 		}
-		var err error
 		api, closer, err := GetAPI(cctx)
 		if err != nil {
 			return err
@@ -113,7 +127,7 @@ var SyncssCmd = &cli.Command{
 
 			log.Infof("loading snapshot file to %s", ssfn)
 			{
-				pb, err := api.Get(ctx, sscid, ssfn)
+				pb, err := api.Get(ctx, sscid, ssfn, fapi.GetOpts{})
 				if err != nil {
 					return err
 				}
@@ -188,17 +202,21 @@ var SyncssCmd = &cli.Command{
 			}
 			if onlyCheck {
 				totalLine++
-				if has, err := api.DagHas(ctx, fcid); has {
-					checkedLine++
-				} else {
+				// every block of the dag must be local, not only the root
+				if res, err := api.DagCheck(ctx, fcid, 1); err != nil {
 					fmt.Printf("%s: %s\n", fcid, err)
 					errLine++
+				} else if !res.Complete {
+					fmt.Printf("%s: %d blocks missing, e.g. %s\n", fcid, res.Missing, res.MissingCids[0])
+					errLine++
+				} else {
+					checkedLine++
 				}
 				fmt.Printf("sum: %d; has: %d; not has: %d\n", totalLine, checkedLine, errLine)
 				continue
 			}
 			if onlyDag {
-				info, err := api.DagSync(ctx, []cid.Cid{fcid}, 32)
+				info, err := api.DagSync(ctx, []cid.Cid{fcid}, 32, pin)
 				if err != nil {
 					return err
 				}
@@ -208,7 +226,10 @@ var SyncssCmd = &cli.Command{
 				continue
 			}
 
-			pb, err := api.Get(ctx, fcid, filepath.Join(p, arr[0]))
+			pb, err := api.Get(ctx, fcid, filepath.Join(p, arr[0]), fapi.GetOpts{
+				Resume: cctx.Bool("resume"),
+				Verify: cctx.Bool("verify"),
+			})
 			if err != nil {
 				return err
 			}
@@ -265,7 +286,7 @@ func writeSlice(lines []string, fname string) error {
 var importDatasetCmd = &cli.Command{
 	Name:  "import-dataset",
 	Usage: "import files from the specified dataset",
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		&cli.StringFlag{
 			Name:     "prefix",
 			Required: true,
@@ -296,7 +317,7 @@ var importDatasetCmd = &cli.Command{
 			Name:  "dscluster",
 			Usage: "path to dscluster config",
 		},
-	},
+	}, append(importFlags, pinFlags...)...),
 	Action: func(cctx *cli.Context) (err error) {
 		ctx := ReqContext(cctx)
 		repoPath := cctx.String("repo")
@@ -309,6 +330,32 @@ var importDatasetCmd = &cli.Command{
 		if err != nil {
 			return err
 		}
+		parallel := cctx.Int("parallel")
+		batchReadNum := cctx.Int("batch-read-num")
+		opts := importOpts(cctx)
+		if !opts.OnlyHash {
+			if opts.Pin, err = pinOpts(cctx); err != nil {
+				return err
+			}
+		}
+
+		tpaths := cctx.Args().Slice()
+		targetPathList := make([]string, 0)
+		for _, targetPath := range tpaths {
+			targetPath, err = homedir.Expand(targetPath)
+			if err != nil {
+				return err
+			}
+			if !filehelper.ExistDir(targetPath) {
+				return xerrors.New("Unexpected! The path to dataset does not exist")
+			}
+			targetPathList = append(targetPathList, targetPath)
+		}
+
+		if opts.OnlyHash {
+			return impl.HashDataset(ctx, opts, parallel, batchReadNum, cctx.String("prefix"), targetPathList)
+		}
+
 		dsclusterCfg := cctx.String("dscluster")
 		var bs bstore.Blockstore
 		if dsclusterCfg != "" {
@@ -344,22 +391,26 @@ var importDatasetCmd = &cli.Command{
 			}
 		}
 
-		parallel := cctx.Int("parallel")
-		batchReadNum := cctx.Int("batch-read-num")
-
-		tpaths := cctx.Args().Slice()
-		targetPathList := make([]string, 0)
-		for _, targetPath := range tpaths {
-			targetPath, err = homedir.Expand(targetPath)
+		var pins *pinner.Pinner
+		if opts.NoCopy || opts.Pin != nil {
+			// the filestore references and the pins are kept in the
+			// node's datastore
+			if opts.NoCopy && !cfg.EnableFilestore {
+				return xerrors.New("filestore is not enabled, set enable_filestore in config")
+			}
+			lds, err := node.OpenDatastore(cfg, repoPath)
 			if err != nil {
 				return err
 			}
-			if !filehelper.ExistDir(targetPath) {
-				return xerrors.New("Unexpected! The path to dataset does not exist")
+			defer lds.Close()
+			if opts.NoCopy {
+				bs = filestore.New(bs, lds)
+			}
+			if opts.Pin != nil {
+				pins = pinner.New(lds)
 			}
-			targetPathList = append(targetPathList, targetPath)
 		}
 
-		return dataset.Import(ctx, bs, merkledag.V0CidPrefix(), parallel, batchReadNum, cctx.String("prefix"), cctx.String("record-dir"), targetPathList)
+		return impl.ImportDataset(ctx, bs, pins, opts, parallel, batchReadNum, cctx.String("prefix"), cctx.String("record-dir"), targetPathList)
 	},
 }
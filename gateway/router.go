@@ -10,18 +10,19 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/filedrive-team/filejoy/node/dagreader"
 	"github.com/gin-gonic/gin"
 	"github.com/ipfs/go-cid"
 	format "github.com/ipfs/go-ipld-format"
 	logging "github.com/ipfs/go-log/v2"
-	ufsio "github.com/ipfs/go-unixfs/io"
 	"golang.org/x/xerrors"
 )
 
 var log = logging.Logger("filejoy-gateway")
 
-// InitRouter - initialize routing information
-func InitRouter(ctx context.Context, dagServ format.DAGService) *gin.Engine {
+// InitRouter - initialize routing information, files are read with window
+// blocks fetched ahead
+func InitRouter(ctx context.Context, dagServ format.DAGService, window int) *gin.Engine {
 
 	r := gin.New()
 	r.Use(gin.Logger())
@@ -56,11 +57,12 @@ func InitRouter(ctx context.Context, dagServ format.DAGService) *gin.Engine {
 			c.JSON(http.StatusNotFound, fmt.Sprintf("could not find cid: %s", cidstr))
 			return
 		}
-		fdr, err := ufsio.NewDagReader(ctx, dagNode, dagServ)
+		fdr, err := dagreader.New(c.Request.Context(), dagNode, dagServ, window)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, err.Error())
 			return
 		}
+		defer fdr.Close()
 		fsize := int64(fdr.Size())
 		var sniffbytes [512]byte
 		n, _ := io.ReadFull(fdr, sniffbytes[:])
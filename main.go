@@ -10,6 +10,7 @@ import (
 	"syscall"
 
 	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/filecoin-project/go-jsonrpc/httpio"
 	"github.com/filedrive-team/filejoy/api"
 	fcli "github.com/filedrive-team/filejoy/cli"
 	"github.com/filedrive-team/filejoy/node"
@@ -77,6 +78,9 @@ var daemonCmd = &cli.Command{
 			return err
 		}
 		defer nd.Close()
+		if nd.Quota != nil && cfg.EnableGC {
+			nd.Quota.SetGC(impl.AutoGC(ctx, nd))
+		}
 
 		// serve rpc
 		var fapi api.FullNode = &impl.FullNodeAPI{
@@ -89,11 +93,22 @@ var daemonCmd = &cli.Command{
 			DagAPI: impl.DagAPI{
 				Node: nd,
 			},
+			FilestoreAPI: impl.FilestoreAPI{
+				Node: nd,
+			},
+			RepoAPI: impl.RepoAPI{
+				Node: nd,
+			},
+			PinAPI: impl.PinAPI{
+				Node: nd,
+			},
 		}
 		m := mux.NewRouter()
-		rpcServer := jsonrpc.NewServer()
+		readerHandler, readerServerOpt := httpio.ReaderParamDecoder()
+		rpcServer := jsonrpc.NewServer(readerServerOpt)
 		rpcServer.Register("Filejoy", fapi)
 		m.Handle(cfg.RPC.Root, rpcServer)
+		m.Handle(path.Join(cfg.RPC.Root, ncfg.StreamPushPath, "{uuid}"), readerHandler)
 
 		srv := &http.Server{
 			Addr:    fmt.Sprintf("%s:%s", cfg.RPC.Host, cfg.RPC.Port),
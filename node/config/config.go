@@ -21,6 +21,10 @@ const remotedscfgpath = "remoteds.json"
 const defaultJSONRPCHost = "0.0.0.0"
 const defaultJSONRPCRoot = "/rpc/v0"
 
+// StreamPushPath is where the rpc server receives io.Reader params, under
+// the rpc root
+const StreamPushPath = "/streams/push"
+
 type Identity struct {
 	PeerID string `json:"peer_id"`
 	SK     []byte `json:"sk"`
@@ -55,6 +59,25 @@ type Config struct {
 	EnableRemoteDS bool        `json:"enable_remote_ds"`
 	GateWayPort    uint        `json:"gateway_port"`
 	Erasure        ErasureConf `json:"erasure"`
+
+	// EnableFilestore allows no-copy imports, which keep references to
+	// the source files instead of the data
+	EnableFilestore bool `json:"enable_filestore"`
+
+	// PrefetchWindow is the number of blocks get and the gateway fetch
+	// ahead while reading a file, 0 means the default of 32
+	PrefetchWindow int `json:"prefetch_window"`
+
+	// StorageMax is the max size of the blockstore, e.g. 500GB, writes are
+	// refused once it is reached. Only enforced for the badger blockstore,
+	// empty means no limit
+	StorageMax string `json:"storage_max"`
+	// StorageGCWatermark is the percentage of StorageMax over which gc is
+	// run if EnableGC is set, 0 means the default of 90
+	StorageGCWatermark int `json:"storage_gc_watermark"`
+	// EnableGC removes the unpinned blocks once the blockstore grows over
+	// the watermark
+	EnableGC bool `json:"enable_gc"`
 }
 
 func LoadOrInitConfig(path string) (*Config, error) {
@@ -0,0 +1,258 @@
+// Package dagreader reads unixfs files with a look-ahead window, the
+// upcoming child blocks of a file are fetched concurrently through a
+// bitswap session instead of one block at a time
+package dagreader
+
+import (
+	"context"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+	pb "github.com/ipfs/go-unixfs/pb"
+	"golang.org/x/xerrors"
+)
+
+var log = logging.Logger("dagreader")
+
+// DefaultWindow is the number of blocks fetched ahead when no window is
+// configured
+const DefaultWindow = 32
+
+// Reader reads a unixfs file, it implements io.ReadSeekCloser
+type Reader struct {
+	ctx    context.Context
+	ng     format.NodeGetter
+	root   format.Node
+	size   uint64
+	window int
+
+	// fetchCtx is canceled when the reader seeks or closes, to drop the
+	// fetches of the previous position
+	fetchCtx context.Context
+	cancel   context.CancelFunc
+	// stack holds the nodes being read, from the root down
+	stack []*frame
+	// buf is the unread data of the current block
+	buf    []byte
+	offset int64
+}
+
+// frame is a node being read, children are fetched up to the window ahead
+// of next
+type frame struct {
+	links   []*format.Link
+	fetches []*fetch
+	next    int
+	started int
+}
+
+type fetch struct {
+	done chan struct{}
+	nd   format.Node
+	err  error
+}
+
+// New returns a reader of the unixfs file nd which keeps up to window
+// blocks in flight, DefaultWindow is used if window is not positive
+func New(ctx context.Context, nd format.Node, ng format.NodeGetter, window int) (*Reader, error) {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	_, _, _, size, err := unpack(nd)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reader{
+		ctx:    ctx,
+		ng:     merkledag.NewSession(ctx, ng),
+		root:   nd,
+		size:   size,
+		window: window,
+	}
+	if err := r.seekTo(0); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// unpack returns the data of a file node, its child links, their file
+// sizes and the size of the whole file
+func unpack(nd format.Node) ([]byte, []*format.Link, []uint64, uint64, error) {
+	switch n := nd.(type) {
+	case *merkledag.RawNode:
+		return n.RawData(), nil, nil, uint64(len(n.RawData())), nil
+	case *merkledag.ProtoNode:
+		fsn, err := unixfs.FSNodeFromBytes(n.Data())
+		if err != nil {
+			return nil, nil, nil, 0, err
+		}
+		if fsn.Type() != pb.Data_File && fsn.Type() != pb.Data_Raw {
+			return nil, nil, nil, 0, xerrors.Errorf("%s is not a file", nd.Cid())
+		}
+		sizes := fsn.BlockSizes()
+		if len(sizes) != len(n.Links()) {
+			return nil, nil, nil, 0, xerrors.Errorf("%s has %d links but %d block sizes", nd.Cid(), len(n.Links()), len(sizes))
+		}
+		return fsn.Data(), n.Links(), sizes, fsn.FileSize(), nil
+	default:
+		return nil, nil, nil, 0, xerrors.Errorf("unsupported node %T", nd)
+	}
+}
+
+// Size returns the size of the file
+func (r *Reader) Size() uint64 {
+	return r.size
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if err := r.advance(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.offset += int64(n)
+	return n, nil
+}
+
+// advance moves to the next block with data, in depth first order
+func (r *Reader) advance() error {
+	for len(r.stack) > 0 {
+		top := r.stack[len(r.stack)-1]
+		if top.next >= len(top.links) {
+			r.stack = r.stack[:len(r.stack)-1]
+			continue
+		}
+		r.prefetch(top)
+		f := top.fetches[top.next]
+		top.fetches[top.next] = nil
+		top.next++
+		select {
+		case <-f.done:
+		case <-r.fetchCtx.Done():
+			return r.fetchCtx.Err()
+		}
+		if f.err != nil {
+			return f.err
+		}
+		data, links, _, _, err := unpack(f.nd)
+		if err != nil {
+			return err
+		}
+		if len(links) > 0 {
+			r.stack = append(r.stack, &frame{
+				links:   links,
+				fetches: make([]*fetch, len(links)),
+			})
+		}
+		if len(data) > 0 {
+			r.buf = data
+			return nil
+		}
+	}
+	return io.EOF
+}
+
+// prefetch starts fetching the children of fr up to the window
+func (r *Reader) prefetch(fr *frame) {
+	for ; fr.started < len(fr.links) && fr.started < fr.next+r.window; fr.started++ {
+		f := &fetch{done: make(chan struct{})}
+		fr.fetches[fr.started] = f
+		go func(ctx context.Context, c cid.Cid) {
+			defer close(f.done)
+			f.nd, f.err = r.ng.Get(ctx, c)
+		}(r.fetchCtx, fr.links[fr.started].Cid)
+	}
+}
+
+// seekTo drops the current position and walks down from the root to the
+// block holding offset
+func (r *Reader) seekTo(offset uint64) error {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.fetchCtx, r.cancel = context.WithCancel(r.ctx)
+	r.stack = r.stack[:0]
+	r.buf = nil
+	r.offset = int64(offset)
+	if offset >= r.size {
+		return nil
+	}
+	nd := r.root
+	for {
+		data, links, sizes, _, err := unpack(nd)
+		if err != nil {
+			return err
+		}
+		if offset < uint64(len(data)) {
+			r.buf = data[offset:]
+			if len(links) > 0 {
+				r.stack = append(r.stack, &frame{
+					links:   links,
+					fetches: make([]*fetch, len(links)),
+				})
+			}
+			return nil
+		}
+		offset -= uint64(len(data))
+		i := 0
+		for i < len(links) && offset >= sizes[i] {
+			offset -= sizes[i]
+			i++
+		}
+		if i == len(links) {
+			return xerrors.Errorf("%s is shorter than its file size", r.root.Cid())
+		}
+		// the children after the one holding offset are read next
+		r.stack = append(r.stack, &frame{
+			links:   links,
+			fetches: make([]*fetch, len(links)),
+			next:    i + 1,
+			started: i + 1,
+		})
+		if nd, err = r.ng.Get(r.fetchCtx, links[i].Cid); err != nil {
+			return err
+		}
+	}
+}
+
+// Seek implements io.Seeker
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += int64(r.size)
+	default:
+		return r.offset, xerrors.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return r.offset, xerrors.New("seek before the start of the file")
+	}
+	if offset == r.offset {
+		return offset, nil
+	}
+	// stay on the current block when possible
+	if offset > r.offset && offset-r.offset < int64(len(r.buf)) {
+		r.buf = r.buf[offset-r.offset:]
+		r.offset = offset
+		return offset, nil
+	}
+	if err := r.seekTo(uint64(offset)); err != nil {
+		log.Warnf("seek %s to %d: %s", r.root.Cid(), offset, err)
+		return r.offset, err
+	}
+	return offset, nil
+}
+
+// Close cancels the fetches in flight
+func (r *Reader) Close() error {
+	r.cancel()
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package node
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileUsage returns the space allocated to a file, less than its size if
+// it is sparse like the preallocated badger value log
+func fileUsage(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Blocks) * 512
+	}
+	return uint64(info.Size())
+}
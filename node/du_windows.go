@@ -0,0 +1,8 @@
+package node
+
+import "os"
+
+// fileUsage returns the size of a file, the allocated space is not known
+func fileUsage(info os.FileInfo) uint64 {
+	return uint64(info.Size())
+}
@@ -0,0 +1,322 @@
+package filestore
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	posinfo "github.com/ipfs/go-ipfs-posinfo"
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+)
+
+var log = logging.Logger("filejoy-filestore")
+
+// RefPrefix is the datastore namespace of the file references
+var RefPrefix = datastore.NewKey("/filestore")
+
+var (
+	// ErrMissing is returned when the source file of a block is gone or
+	// shorter than the reference
+	ErrMissing = xerrors.New("filestore: source file is missing")
+	// ErrChanged is returned when the source file data no longer matches
+	// the block hash
+	ErrChanged = xerrors.New("filestore: source file has changed")
+)
+
+// DataRef locates the data of a block in a source file
+type DataRef struct {
+	Path   string `json:"path"`
+	Offset uint64 `json:"offset"`
+	Size   uint64 `json:"size"`
+}
+
+// Filestore is a blockstore which keeps the raw leaves added with position
+// info as references to their source files, every other block is stored in
+// the wrapped blockstore
+type Filestore struct {
+	bs   blockstore.Blockstore
+	refs datastore.Batching
+}
+
+var _ blockstore.Blockstore = &Filestore{}
+
+// New wraps bs, the references are kept under RefPrefix of ds
+func New(bs blockstore.Blockstore, ds datastore.Batching) *Filestore {
+	return &Filestore{
+		bs:   bs,
+		refs: namespace.Wrap(ds, RefPrefix),
+	}
+}
+
+func refKey(c cid.Cid) datastore.Key {
+	return dshelp.MultihashToDsKey(c.Hash())
+}
+
+// Ref returns the file reference of c
+func (fs *Filestore) Ref(c cid.Cid) (*DataRef, error) {
+	v, err := fs.refs.Get(refKey(c))
+	if err != nil {
+		if err == datastore.ErrNotFound {
+			return nil, blockstore.ErrNotFound
+		}
+		return nil, err
+	}
+	ref := &DataRef{}
+	if err := json.Unmarshal(v, ref); err != nil {
+		return nil, err
+	}
+	return ref, nil
+}
+
+// ReadRef reads the data ref points to and checks it against the hash of c
+func ReadRef(c cid.Cid, ref *DataRef) ([]byte, error) {
+	f, err := os.Open(ref.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, xerrors.Errorf("%s: %w", ref.Path, ErrMissing)
+		}
+		return nil, err
+	}
+	defer f.Close()
+	data := make([]byte, ref.Size)
+	if _, err := f.ReadAt(data, int64(ref.Offset)); err != nil {
+		if err == io.EOF {
+			return nil, xerrors.Errorf("%s: %w", ref.Path, ErrMissing)
+		}
+		return nil, err
+	}
+	sum, err := c.Prefix().Sum(data)
+	if err != nil {
+		return nil, err
+	}
+	if string(sum.Hash()) != string(c.Hash()) {
+		return nil, xerrors.Errorf("%s at offset %d: %w", ref.Path, ref.Offset, ErrChanged)
+	}
+	return data, nil
+}
+
+// DeleteBlock removes c from the wrapped blockstore and its file reference,
+// a block kept only as a reference is deleted once the reference is
+func (fs *Filestore) DeleteBlock(c cid.Cid) error {
+	hasRef, err := fs.refs.Has(refKey(c))
+	if err != nil {
+		return err
+	}
+	err = fs.bs.DeleteBlock(c)
+	if hasRef {
+		if rerr := fs.refs.Delete(refKey(c)); rerr != nil && rerr != datastore.ErrNotFound {
+			return rerr
+		}
+		if err == blockstore.ErrNotFound {
+			return nil
+		}
+	}
+	return err
+}
+
+func (fs *Filestore) Has(c cid.Cid) (bool, error) {
+	has, err := fs.bs.Has(c)
+	if err != nil || has {
+		return has, err
+	}
+	return fs.refs.Has(refKey(c))
+}
+
+func (fs *Filestore) Get(c cid.Cid) (blocks.Block, error) {
+	blk, err := fs.bs.Get(c)
+	if err != blockstore.ErrNotFound {
+		return blk, err
+	}
+	ref, err := fs.Ref(c)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ReadRef(c, ref)
+	if err != nil {
+		log.Warnf("read %s: %s", c, err)
+		return nil, err
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+func (fs *Filestore) GetSize(c cid.Cid) (int, error) {
+	size, err := fs.bs.GetSize(c)
+	if err != blockstore.ErrNotFound {
+		return size, err
+	}
+	ref, err := fs.Ref(c)
+	if err != nil {
+		return -1, err
+	}
+	return int(ref.Size), nil
+}
+
+// Put keeps a posinfo.FilestoreNode as a file reference, other blocks are
+// put into the wrapped blockstore
+func (fs *Filestore) Put(b blocks.Block) error {
+	fsn, ok := b.(*posinfo.FilestoreNode)
+	if !ok {
+		return fs.bs.Put(b)
+	}
+	v, err := marshalRef(fsn)
+	if err != nil {
+		return err
+	}
+	return fs.refs.Put(refKey(b.Cid()), v)
+}
+
+func (fs *Filestore) PutMany(bs []blocks.Block) error {
+	var others []blocks.Block
+	batch, err := fs.refs.Batch()
+	if err != nil {
+		return err
+	}
+	for _, b := range bs {
+		fsn, ok := b.(*posinfo.FilestoreNode)
+		if !ok {
+			others = append(others, b)
+			continue
+		}
+		v, err := marshalRef(fsn)
+		if err != nil {
+			return err
+		}
+		if err := batch.Put(refKey(b.Cid()), v); err != nil {
+			return err
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		return err
+	}
+	if len(others) > 0 {
+		return fs.bs.PutMany(others)
+	}
+	return nil
+}
+
+func marshalRef(fsn *posinfo.FilestoreNode) ([]byte, error) {
+	if fsn.PosInfo == nil || fsn.PosInfo.FullPath == "" {
+		return nil, xerrors.Errorf("no file position of block %s", fsn.Cid())
+	}
+	return json.Marshal(&DataRef{
+		Path:   fsn.PosInfo.FullPath,
+		Offset: fsn.PosInfo.Offset,
+		Size:   uint64(len(fsn.RawData())),
+	})
+}
+
+// AllKeysChan returns the keys of the wrapped blockstore followed by the
+// referenced blocks, which are returned as raw cids
+func (fs *Filestore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	bsch, err := fs.bs.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan cid.Cid)
+	go func() {
+		defer close(out)
+		for c := range bsch {
+			select {
+			case out <- c:
+			case <-ctx.Done():
+				return
+			}
+		}
+		res, err := fs.refs.Query(query.Query{KeysOnly: true})
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		defer res.Close()
+		for r := range res.Next() {
+			if r.Error != nil {
+				log.Error(r.Error)
+				return
+			}
+			c, err := dshelp.DsKeyToCidV1(datastore.RawKey(r.Key), cid.Raw)
+			if err != nil {
+				log.Warnf("bad filestore key %s: %s", r.Key, err)
+				continue
+			}
+			select {
+			case out <- c:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (fs *Filestore) HashOnRead(enabled bool) {
+	fs.bs.HashOnRead(enabled)
+}
+
+// reference status reported by Verify
+const (
+	StatusOK      = "ok"
+	StatusMissing = "missing"
+	StatusChanged = "changed"
+	StatusError   = "error"
+)
+
+// RefStatus is the verify result of a file reference
+type RefStatus struct {
+	Cid    cid.Cid
+	Ref    DataRef
+	Status string
+	Err    error
+}
+
+// Verify reads every file reference and checks the data against its hash
+func (fs *Filestore) Verify(ctx context.Context) (<-chan *RefStatus, error) {
+	res, err := fs.refs.Query(query.Query{})
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan *RefStatus)
+	go func() {
+		defer close(out)
+		defer res.Close()
+		for r := range res.Next() {
+			st := &RefStatus{}
+			if r.Error != nil {
+				st.Status = StatusError
+				st.Err = r.Error
+			} else {
+				st.Cid, st.Err = dshelp.DsKeyToCidV1(datastore.RawKey(r.Key), cid.Raw)
+				if st.Err == nil {
+					st.Err = json.Unmarshal(r.Value, &st.Ref)
+				}
+				if st.Err == nil {
+					_, st.Err = ReadRef(st.Cid, &st.Ref)
+				}
+				switch {
+				case st.Err == nil:
+					st.Status = StatusOK
+				case xerrors.Is(st.Err, ErrMissing):
+					st.Status = StatusMissing
+				case xerrors.Is(st.Err, ErrChanged):
+					st.Status = StatusChanged
+				default:
+					st.Status = StatusError
+				}
+			}
+			select {
+			case out <- st:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
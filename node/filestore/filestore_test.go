@@ -0,0 +1,55 @@
+package filestore
+
+import (
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	posinfo "github.com/ipfs/go-ipfs-posinfo"
+	"github.com/ipfs/go-merkledag"
+)
+
+// strictBlockstore fails to delete the blocks it does not have
+type strictBlockstore struct {
+	blockstore.Blockstore
+}
+
+func (bs strictBlockstore) DeleteBlock(c cid.Cid) error {
+	has, err := bs.Has(c)
+	if err != nil {
+		return err
+	}
+	if !has {
+		return blockstore.ErrNotFound
+	}
+	return bs.Blockstore.DeleteBlock(c)
+}
+
+func TestDeleteBlock(t *testing.T) {
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	fs := New(strictBlockstore{blockstore.NewBlockstore(ds)}, ds)
+	ref := &posinfo.FilestoreNode{
+		Node:    merkledag.NewRawNode([]byte("referenced")),
+		PosInfo: &posinfo.PosInfo{FullPath: "/data/file"},
+	}
+	stored := blocks.NewBlock([]byte("stored"))
+	if err := fs.PutMany([]blocks.Block{ref, stored}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a block kept only as a reference is deleted with its reference
+	for _, b := range []blocks.Block{ref, stored} {
+		if err := fs.DeleteBlock(b.Cid()); err != nil {
+			t.Fatalf("delete %s: %v", b.Cid(), err)
+		}
+		if has, err := fs.Has(b.Cid()); err != nil || has {
+			t.Fatalf("deleted %s still there: %t, %v", b.Cid(), has, err)
+		}
+		if err := fs.DeleteBlock(b.Cid()); err != blockstore.ErrNotFound {
+			t.Errorf("delete %s twice: %v, expected %v", b.Cid(), err, blockstore.ErrNotFound)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package impl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/filedrive-team/filejoy/api"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	format "github.com/ipfs/go-ipld-format"
+)
+
+// add2CheckpointPrefix is the datastore namespace of Add2 checkpoints
+var add2CheckpointPrefix = datastore.NewKey("/add2-checkpoint")
+
+// importCheckpoint keeps the leaf links of an Add2 import in the datastore,
+// an interrupted import of the same file with the same size, mtime and
+// import options resumes after the last completed batch
+type importCheckpoint struct {
+	ds     datastore.Batching
+	key    datastore.Key
+	path   string
+	links  []*IdxLink
+	offset uint64
+}
+
+type checkpointHead struct {
+	Path   string `json:"path"`
+	Next   int    `json:"next"`
+	Offset uint64 `json:"offset"`
+}
+
+type checkpointLink struct {
+	Cid      string `json:"cid"`
+	Size     uint64 `json:"size"`
+	FileSize uint64 `json:"file_size"`
+}
+
+func hashKey(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// openCheckpoint loads the checkpoint of the file at path, checkpoints of
+// previous versions of the file are dropped
+func openCheckpoint(ds datastore.Batching, path string, finfo os.FileInfo, opts api.ImportOpts) (*importCheckpoint, error) {
+	// pinning does not change the dag
+	opts.Pin = nil
+	state, err := json.Marshal(struct {
+		Size    int64
+		ModTime int64
+		Opts    api.ImportOpts
+	}{
+		Size:    finfo.Size(),
+		ModTime: finfo.ModTime().UnixNano(),
+		Opts:    opts,
+	})
+	if err != nil {
+		return nil, err
+	}
+	pathKey := add2CheckpointPrefix.ChildString(hashKey([]byte(path)))
+	cp := &importCheckpoint{
+		ds:   ds,
+		key:  pathKey.ChildString(hashKey(state)),
+		path: path,
+	}
+	if err := deleteKeys(ds, pathKey, func(k datastore.Key) bool {
+		return k != cp.key && !cp.key.IsAncestorOf(k)
+	}); err != nil {
+		return nil, err
+	}
+
+	v, err := ds.Get(cp.key)
+	if err == datastore.ErrNotFound {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	head := &checkpointHead{}
+	if err := json.Unmarshal(v, head); err != nil {
+		return nil, err
+	}
+	cp.links = make([]*IdxLink, 0, head.Next)
+	for i := 0; i < head.Next; i++ {
+		v, err := ds.Get(cp.linkKey(i))
+		if err != nil {
+			return nil, err
+		}
+		cl := &checkpointLink{}
+		if err := json.Unmarshal(v, cl); err != nil {
+			return nil, err
+		}
+		c, err := cid.Decode(cl.Cid)
+		if err != nil {
+			return nil, err
+		}
+		cp.links = append(cp.links, &IdxLink{
+			Idx: i,
+			Link: &format.Link{
+				Size: cl.Size,
+				Cid:  c,
+			},
+			FileSize: cl.FileSize,
+		})
+	}
+	cp.offset = head.Offset
+	return cp, nil
+}
+
+func (cp *importCheckpoint) linkKey(idx int) datastore.Key {
+	return cp.key.ChildString(fmt.Sprintf("%012d", idx))
+}
+
+// save records a batch of completed leaf links, offset is where the next
+// batch starts in the file
+func (cp *importCheckpoint) save(links []*IdxLink, offset uint64) error {
+	batch, err := cp.ds.Batch()
+	if err != nil {
+		return err
+	}
+	next := 0
+	for _, lk := range links {
+		v, err := json.Marshal(&checkpointLink{
+			Cid:      lk.Link.Cid.String(),
+			Size:     lk.Link.Size,
+			FileSize: lk.FileSize,
+		})
+		if err != nil {
+			return err
+		}
+		if err := batch.Put(cp.linkKey(lk.Idx), v); err != nil {
+			return err
+		}
+		if lk.Idx >= next {
+			next = lk.Idx + 1
+		}
+	}
+	v, err := json.Marshal(&checkpointHead{
+		Path:   cp.path,
+		Next:   next,
+		Offset: offset,
+	})
+	if err != nil {
+		return err
+	}
+	if err := batch.Put(cp.key, v); err != nil {
+		return err
+	}
+	return batch.Commit()
+}
+
+// remove drops the checkpoint once the import is done
+func (cp *importCheckpoint) remove() error {
+	return deleteKeys(cp.ds, cp.key, func(datastore.Key) bool { return true })
+}
+
+// deleteKeys deletes prefix and the keys under it which match
+func deleteKeys(ds datastore.Batching, prefix datastore.Key, match func(datastore.Key) bool) error {
+	res, err := ds.Query(query.Query{Prefix: prefix.String(), KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		return err
+	}
+	batch, err := ds.Batch()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		k := datastore.NewKey(e.Key)
+		if match(k) {
+			if err := batch.Delete(k); err != nil {
+				return err
+			}
+		}
+	}
+	if match(prefix) {
+		if err := batch.Delete(prefix); err != nil {
+			return err
+		}
+	}
+	return batch.Commit()
+}
+
+// checkpointRoots returns the links recorded by all the checkpoints, the
+// blocks below them are needed to resume the imports
+func checkpointRoots(ds datastore.Batching) ([]cid.Cid, error) {
+	res, err := ds.Query(query.Query{Prefix: add2CheckpointPrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	var roots []cid.Cid
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		cl := &checkpointLink{}
+		if err := json.Unmarshal(r.Value, cl); err != nil {
+			return nil, err
+		}
+		// checkpoint heads have no cid
+		if cl.Cid == "" {
+			continue
+		}
+		c, err := cid.Decode(cl.Cid)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, c)
+	}
+	return roots, nil
+}
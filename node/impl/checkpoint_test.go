@@ -0,0 +1,107 @@
+package impl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/filedrive-team/filejoy/api"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"golang.org/x/xerrors"
+)
+
+// failingDAG fails every add once limit nodes are added
+type failingDAG struct {
+	format.DAGService
+	added int64
+	limit int64
+}
+
+var errDAGFull = xerrors.New("interrupted")
+
+func (d *failingDAG) Add(ctx context.Context, nd format.Node) error {
+	if atomic.AddInt64(&d.added, 1) > d.limit {
+		return errDAGFull
+	}
+	return d.DAGService.Add(ctx, nd)
+}
+
+func (d *failingDAG) AddMany(ctx context.Context, nds []format.Node) error {
+	if atomic.AddInt64(&d.added, int64(len(nds))) > d.limit {
+		return errDAGFull
+	}
+	return d.DAGService.AddMany(ctx, nds)
+}
+
+// add2 runs Add2 to the end and returns its reports
+func add2(t *testing.T, a *CommonAPI, path string, opts api.ImportOpts) []api.PBar {
+	t.Helper()
+	out, err := a.Add2(context.Background(), path, 4, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pbs []api.PBar
+	for pb := range out {
+		pbs = append(pbs, pb)
+	}
+	if len(pbs) == 0 {
+		t.Fatal("no report")
+	}
+	return pbs
+}
+
+// addedCid returns the root reported by a successful add
+func addedCid(t *testing.T, pbs []api.PBar) cid.Cid {
+	t.Helper()
+	last := pbs[len(pbs)-1]
+	if last.Err != "" {
+		t.Fatalf("add: %s", last.Err)
+	}
+	c, err := cid.Decode(strings.TrimPrefix(last.Msg, "Add Success: "))
+	if err != nil {
+		t.Fatalf("add reported %q: %v", last.Msg, err)
+	}
+	return c
+}
+
+func TestAdd2Resume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(path, randData(1, 40<<10+100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, layout := range []string{LayoutBalanced, LayoutTrickle} {
+		t.Run(layout, func(t *testing.T) {
+			opts := api.ImportOpts{
+				Chunker: "size-1024",
+				Layout:  layout,
+			}
+			expected := addedCid(t, add2(t, &CommonAPI{Node: newTestNode(t)}, path, opts))
+
+			n := newTestNode(t)
+			dagServ := n.Dagserv
+			// the fifth batch of 4 leaves fails
+			n.Dagserv = &failingDAG{DAGService: dagServ, limit: 18}
+			a := &CommonAPI{Node: n}
+			pbs := add2(t, a, path, opts)
+			if last := pbs[len(pbs)-1]; last.Err == "" {
+				t.Fatalf("interrupted add: %s", last.Msg)
+			}
+
+			n.Dagserv = dagServ
+			pbs = add2(t, a, path, opts)
+			if !strings.HasPrefix(pbs[0].Msg, "Resume: 16 chunks") {
+				t.Errorf("first report of the resumed add: %q", pbs[0].Msg)
+			}
+			if c := addedCid(t, pbs); !c.Equals(expected) {
+				t.Errorf("resumed add root %s, expected %s", c, expected)
+			}
+			if roots, err := checkpointRoots(n.Datastore); err != nil || len(roots) != 0 {
+				t.Errorf("checkpoint left after the add: %v, %v", roots, err)
+			}
+		})
+	}
+}
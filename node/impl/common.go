@@ -1,19 +1,24 @@
 package impl
 
 import (
+	"archive/tar"
 	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"time"
 
-	"github.com/filedrive-team/filehelper"
-	"github.com/filedrive-team/filehelper/importer"
 	"github.com/filedrive-team/filejoy/api"
 	"github.com/filedrive-team/filejoy/node"
+	"github.com/filedrive-team/filejoy/node/dagreader"
 	"github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	format "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
 	ufsio "github.com/ipfs/go-unixfs/io"
 	"golang.org/x/xerrors"
 )
@@ -22,10 +27,9 @@ type CommonAPI struct {
 	Node *node.Node
 }
 
-func (a *CommonAPI) Add(ctx context.Context, path string) (chan api.PBar, error) {
-	// cidbuilder
-	cidBuilder, err := merkledag.PrefixForCidVersion(0)
-	if err != nil {
+func (a *CommonAPI) Add(ctx context.Context, path string, opts api.ImportOpts) (chan api.PBar, error) {
+	// validate options before any work
+	if err := CheckImportOpts(opts); err != nil {
 		return nil, err
 	}
 	finfo, err := os.Stat(path)
@@ -35,6 +39,9 @@ func (a *CommonAPI) Add(ctx context.Context, path string) (chan api.PBar, error)
 	if finfo.IsDir() {
 		return nil, xerrors.Errorf("%s is dir, add only works on file", path)
 	}
+	if err := a.checkNoCopy(opts); err != nil {
+		return nil, err
+	}
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -42,137 +49,586 @@ func (a *CommonAPI) Add(ctx context.Context, path string) (chan api.PBar, error)
 	pb := &pbar{
 		Total: finfo.Size(),
 	}
-	iodone := make(chan struct{})
-	ioerr := make(chan error)
-	out := make(chan api.PBar)
+	r, err := importReader(path, io.TeeReader(f, pb), finfo, opts)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	dagServ := a.importDagServ(opts)
+	out := runWithProgress(ctx, pb, func(send func(api.PBar)) api.PBar {
+		defer a.Node.GCLocker.PinLock().Unlock()
+		defer f.Close()
+		nd, err := BuildFileNode(ctx, r, dagServ, opts, fileMeta(finfo, opts))
+		if err == nil {
+			a.recordImport(nd.Cid(), opts)
+			err = a.pinAdded(nd.Cid(), opts)
+		}
+		if err != nil {
+			return api.PBar{
+				Total:   pb.Total,
+				Current: pb.Current,
+				Err:     err.Error(),
+				Msg:     fmt.Sprintf("Add Failed: %s", err),
+			}
+		}
+		return api.PBar{
+			Total:   pb.Total,
+			Current: pb.Total,
+			Msg:     addSuccessMsg(nd.Cid(), dagServ),
+		}
+	})
+	return out, nil
+}
 
-	go func(p chan api.PBar, iodone chan struct{}, ioerr chan error) {
-		defer close(out)
-		tic := time.NewTicker(time.Millisecond * 50)
-		for {
-			select {
-			case <-ctx.Done():
-				out <- api.PBar{
+func (a *CommonAPI) AddDir(ctx context.Context, path string, opts api.ImportOpts) (chan api.PBar, error) {
+	if err := CheckImportOpts(opts); err != nil {
+		return nil, err
+	}
+	// cidbuilder
+	cidBuilder, err := NewCidBuilder(opts.CidVersion, opts.HashFunc)
+	if err != nil {
+		return nil, err
+	}
+	finfo, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !finfo.IsDir() {
+		return nil, xerrors.Errorf("%s is not dir", path)
+	}
+	if err := a.checkNoCopy(opts); err != nil {
+		return nil, err
+	}
+	total, err := dirSize(path)
+	if err != nil {
+		return nil, err
+	}
+	pb := &pbar{
+		Total: total,
+	}
+	dagServ := a.importDagServ(opts)
+	out := runWithProgress(ctx, pb, func(send func(api.PBar)) api.PBar {
+		defer a.Node.GCLocker.PinLock().Unlock()
+		db := &dirBuilder{
+			dagServ:      dagServ,
+			cidBuilder:   cidBuilder,
+			opts:         opts,
+			shardingSize: HAMTShardingSize,
+			pb:           pb,
+			onFile: func(p string, c cid.Cid) {
+				a.recordImport(c, opts)
+				rel, err := filepath.Rel(filepath.Dir(path), p)
+				if err != nil {
+					rel = p
+				}
+				send(api.PBar{
 					Total:   pb.Total,
 					Current: pb.Current,
-					Err:     ctx.Err().Error(),
-				}
-				return
-			case <-iodone:
-				return
-			case <-ioerr:
-				return
-			case <-tic.C:
-				if pb.Done() {
-					out <- api.PBar{
-						Total:   pb.Total,
-						Current: pb.Current,
-					}
-				} else {
-					out <- api.PBar{
-						Total:   pb.Total,
-						Current: pb.Current,
-					}
-				}
-			}
+					Msg:     fmt.Sprintf("added %s %s", c, rel),
+				})
+			},
+		}
+		nd, err := db.add(ctx, path)
+		if err == nil {
+			err = a.pinAdded(nd.Cid(), opts)
 		}
-	}(out, iodone, ioerr)
-	go func(iodone chan struct{}, ioerr chan error) {
-		nd, err := filehelper.BalanceNode(io.TeeReader(f, pb), a.Node.Dagserv, cidBuilder)
 		if err != nil {
-			ioerr <- err
-			out <- api.PBar{
+			return api.PBar{
 				Total:   pb.Total,
 				Current: pb.Current,
 				Err:     err.Error(),
 				Msg:     fmt.Sprintf("Add Failed: %s", err),
 			}
-			return
 		}
-		out <- api.PBar{
+		return api.PBar{
 			Total:   pb.Total,
 			Current: pb.Total,
-			Msg:     fmt.Sprintf("Add Success: %s", nd.Cid()),
+			Msg:     addSuccessMsg(nd.Cid(), dagServ),
 		}
-		iodone <- struct{}{}
-	}(iodone, ioerr)
-	return out, err
+	})
+	return out, nil
 }
 
-func (a *CommonAPI) Get(ctx context.Context, cid cid.Cid, path string) (chan api.PBar, error) {
-	dagNode, err := a.Node.Dagserv.Get(ctx, cid)
+// AddReader imports the data streamed from the client as a file
+func (a *CommonAPI) AddReader(ctx context.Context, r io.Reader, opts api.ImportOpts) (chan api.PBar, error) {
+	if err := CheckImportOpts(opts); err != nil {
+		return nil, err
+	}
+	if opts.NoCopy {
+		return nil, xerrors.New("no-copy is not supported for streamed data")
+	}
+	// the size of a stream is unknown
+	pb := &pbar{
+		Total: -1,
+	}
+	dagServ := a.importDagServ(opts)
+	out := runWithProgress(ctx, pb, func(send func(api.PBar)) api.PBar {
+		defer a.Node.GCLocker.PinLock().Unlock()
+		nd, err := BuildFileNode(ctx, io.TeeReader(r, pb), dagServ, opts, FileMeta{})
+		if err == nil {
+			a.recordImport(nd.Cid(), opts)
+			err = a.pinAdded(nd.Cid(), opts)
+		}
+		if err != nil {
+			// drain the stream, so that the client upload finishes
+			io.Copy(ioutil.Discard, r)
+			return api.PBar{
+				Total:   pb.Total,
+				Current: pb.Current,
+				Err:     err.Error(),
+				Msg:     fmt.Sprintf("Add Failed: %s", err),
+			}
+		}
+		return api.PBar{
+			Total:   pb.Current,
+			Current: pb.Current,
+			Msg:     addSuccessMsg(nd.Cid(), dagServ),
+		}
+	})
+	return out, nil
+}
+
+// AddTar imports the tar archive streamed from the client as a unixfs
+// directory, gzip compressed archives are detected. File modes and mtimes
+// in the archive are kept as unixfs metadata
+func (a *CommonAPI) AddTar(ctx context.Context, r io.Reader, opts api.ImportOpts) (chan api.PBar, error) {
+	if err := CheckImportOpts(opts); err != nil {
+		return nil, err
+	}
+	if opts.NoCopy {
+		return nil, xerrors.New("no-copy is not supported for streamed data")
+	}
+	cidBuilder, err := NewCidBuilder(opts.CidVersion, opts.HashFunc)
 	if err != nil {
 		return nil, err
 	}
-	fdr, err := ufsio.NewDagReader(ctx, dagNode, a.Node.Dagserv)
+	// the size of a stream is unknown
+	pb := &pbar{
+		Total: -1,
+	}
+	dagServ := a.importDagServ(opts)
+	out := runWithProgress(ctx, pb, func(send func(api.PBar)) api.PBar {
+		defer a.Node.GCLocker.PinLock().Unlock()
+		tb := &tarBuilder{
+			dagServ:      dagServ,
+			cidBuilder:   cidBuilder,
+			opts:         opts,
+			shardingSize: HAMTShardingSize,
+			pb:           pb,
+			onFile: func(p string, c cid.Cid) {
+				a.recordImport(c, opts)
+				send(api.PBar{
+					Total:   pb.Total,
+					Current: pb.Current,
+					Msg:     fmt.Sprintf("added %s %s", c, p),
+				})
+			},
+		}
+		nd, err := tb.importTar(ctx, r)
+		if err == nil {
+			err = a.pinAdded(nd.Cid(), opts)
+		}
+		if err != nil {
+			// drain the stream, so that the client upload finishes
+			io.Copy(ioutil.Discard, r)
+			return api.PBar{
+				Total:   pb.Total,
+				Current: pb.Current,
+				Err:     err.Error(),
+				Msg:     fmt.Sprintf("Add Failed: %s", err),
+			}
+		}
+		return api.PBar{
+			Total:   pb.Current,
+			Current: pb.Current,
+			Msg:     addSuccessMsg(nd.Cid(), dagServ),
+		}
+	})
+	return out, nil
+}
+
+// Get writes the file or directory tree of c to path
+func (a *CommonAPI) Get(ctx context.Context, c cid.Cid, path string, opts api.GetOpts) (chan api.PBar, error) {
+	dagNode, err := a.Node.Dagserv.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	size, err := treeSize(ctx, a.Node.Dagserv, dagNode)
 	if err != nil {
 		return nil, err
 	}
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return nil, err
 	}
-	f, err := os.Create(path)
+
+	pb := &pbar{
+		Total: size,
+	}
+	tw := &treeWriter{
+		dagServ: a.Node.Dagserv,
+		ds:      a.Node.Datastore,
+		window:  a.Node.Config.PrefetchWindow,
+		pb:      pb,
+	}
+	out := runWithProgress(ctx, pb, func(send func(api.PBar)) api.PBar {
+		if err := tw.writeTree(ctx, dagNode, path, opts); err != nil {
+			return api.PBar{
+				Total:   pb.Total,
+				Current: pb.Current,
+				Err:     err.Error(),
+			}
+		}
+		// metadata is restored after the data is written, so the progress
+		// only completes here
+		res := api.PBar{
+			Total:   pb.Total,
+			Current: pb.Total,
+		}
+		if opts.Verify {
+			res.Msg = fmt.Sprintf("Verified: %s", c)
+		}
+		return res
+	})
+	return out, nil
+}
+
+// GetTar streams the file or directory tree of c as a tar archive, with c
+// as the root entry
+func (a *CommonAPI) GetTar(ctx context.Context, c cid.Cid) (chan api.CatChunk, error) {
+	dagNode, err := a.Node.Dagserv.Get(ctx, c)
 	if err != nil {
 		return nil, err
 	}
+	if _, _, err := unixfsKind(dagNode); err != nil {
+		return nil, err
+	}
+	tw := &treeWriter{
+		dagServ: a.Node.Dagserv,
+		window:  a.Node.Config.PrefetchWindow,
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		w := tar.NewWriter(pw)
+		err := tw.writeTar(ctx, dagNode, c.String(), w)
+		if err == nil {
+			err = w.Close()
+		}
+		pw.CloseWithError(err)
+	}()
 
-	pb := &pbar{
-		Total: int64(fdr.Size()),
+	out := make(chan api.CatChunk)
+	go func() {
+		defer close(out)
+		// the writer fails on the closed pipe if the client is gone
+		defer pr.Close()
+		if err := streamChunks(ctx, pr, out); err != nil {
+			log.Warnf("tar %s: %s", c, err)
+		}
+	}()
+	return out, nil
+}
+
+// catChunkSize is the size of the data in each chunk streamed by Cat
+const catChunkSize = 256 << 10
+
+// Cat streams length bytes of the file cid from offset, a length of 0 or
+// less reads to the end of the file
+func (a *CommonAPI) Cat(ctx context.Context, cid cid.Cid, offset, length int64) (chan api.CatChunk, error) {
+	if offset < 0 {
+		return nil, xerrors.Errorf("invalid offset %d", offset)
+	}
+	dagNode, err := a.Node.Dagserv.Get(ctx, cid)
+	if err != nil {
+		return nil, err
+	}
+	if kind, _, err := unixfsKind(dagNode); err != nil {
+		return nil, err
+	} else if kind != kindFile {
+		return nil, xerrors.Errorf("%s is not a file", cid)
+	}
+	fdr, err := dagreader.New(ctx, dagNode, a.Node.Dagserv, a.Node.Config.PrefetchWindow)
+	if err != nil {
+		return nil, err
+	}
+	if offset > int64(fdr.Size()) {
+		fdr.Close()
+		return nil, xerrors.Errorf("offset %d is beyond the file size %d", offset, fdr.Size())
+	}
+	if _, err := fdr.Seek(offset, io.SeekStart); err != nil {
+		fdr.Close()
+		return nil, err
+	}
+	var r io.Reader = fdr
+	if length > 0 {
+		r = io.LimitReader(fdr, length)
 	}
-	iodone := make(chan struct{})
-	ioerr := make(chan error)
-	out := make(chan api.PBar)
 
-	go func(p chan api.PBar, iodone chan struct{}, ioerr chan error) {
+	out := make(chan api.CatChunk)
+	go func() {
 		defer close(out)
-		tic := time.NewTicker(time.Millisecond * 50)
-		for {
+		defer fdr.Close()
+		if err := streamChunks(ctx, r, out); err != nil {
+			log.Warnf("cat %s: %s", cid, err)
+		}
+	}()
+	return out, nil
+}
+
+// streamChunks sends the data of r to out in chunks of catChunkSize, then
+// an EOF chunk, or an Err chunk and the read error if reading fails. It
+// gives up once ctx is done
+func streamChunks(ctx context.Context, r io.Reader, out chan api.CatChunk) error {
+	for {
+		// every chunk gets its own buffer, it is encoded after the send
+		buf := make([]byte, catChunkSize)
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
 			select {
+			case out <- api.CatChunk{Data: buf[:n]}:
 			case <-ctx.Done():
-				out <- api.PBar{
-					Total:   pb.Total,
-					Current: pb.Current,
-					Err:     ctx.Err().Error(),
-				}
-				return
-			case <-iodone:
-				out <- api.PBar{
-					Total:   pb.Total,
-					Current: pb.Total,
-				}
-				return
-			case e := <-ioerr:
-				out <- api.PBar{
-					Total:   pb.Total,
-					Current: pb.Current,
-					Err:     e.Error(),
-				}
-				return
-			case <-tic.C:
-				if pb.Done() {
-					out <- api.PBar{
-						Total:   pb.Total,
-						Current: pb.Current,
-					}
-					return
-				}
-				out <- api.PBar{
-					Total:   pb.Total,
-					Current: pb.Current,
-				}
+				return nil
 			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			select {
+			case out <- api.CatChunk{EOF: true}:
+			case <-ctx.Done():
+			}
+			return nil
+		}
+		if err != nil {
+			select {
+			case out <- api.CatChunk{Err: err.Error()}:
+			case <-ctx.Done():
+			}
+			return err
+		}
+	}
+}
+
+// importDagServ returns the dag service which imports write to, nodes are
+// only counted and discarded in only hash mode
+func (a *CommonAPI) importDagServ(opts api.ImportOpts) format.DAGService {
+	if opts.OnlyHash {
+		return NewDiscardDAG()
+	}
+	return a.Node.Dagserv
+}
+
+// checkNoCopy makes sure no-copy imports have a filestore to keep the
+// references
+func (a *CommonAPI) checkNoCopy(opts api.ImportOpts) error {
+	if opts.NoCopy && !opts.OnlyHash && a.Node.Filestore == nil {
+		return xerrors.New("filestore is not enabled, set enable_filestore in config")
+	}
+	return nil
+}
+
+// importReader carries the source file info along with r for no-copy imports
+func importReader(path string, r io.Reader, finfo os.FileInfo, opts api.ImportOpts) (io.Reader, error) {
+	if !opts.NoCopy {
+		return r, nil
+	}
+	return files.NewReaderPathFile(path, ioutil.NopCloser(r), finfo)
+}
+
+// recordImport records the import options of the added file c, so that get
+// can verify the file. Failing to is only logged, verify then tells the
+// options from the dag
+func (a *CommonAPI) recordImport(c cid.Cid, opts api.ImportOpts) {
+	if opts.OnlyHash {
+		return
+	}
+	if err := saveImportOpts(a.Node.Datastore, c, opts); err != nil {
+		log.Warnf("record import options of %s: %s", c, err)
+	}
+}
+
+// pinAdded pins the root of an import if asked to
+func (a *CommonAPI) pinAdded(c cid.Cid, opts api.ImportOpts) error {
+	if opts.OnlyHash {
+		return nil
+	}
+	return pinRoot(a.Node, c, opts.Pin)
+}
+
+func addSuccessMsg(c cid.Cid, dagServ format.DAGService) string {
+	if dd, ok := dagServ.(*DiscardDAG); ok {
+		return fmt.Sprintf("Only Hash: %s, would store %d blocks, %d bytes", c, dd.Blocks(), dd.Bytes())
+	}
+	return fmt.Sprintf("Add Success: %s", c)
+}
+
+// HAMTShardingSize is the estimated directory block size above which
+// AddDir switches a directory to a HAMT sharded one, same as go-ipfs
+const HAMTShardingSize = 256 << 10
+
+// shardedDir is a unixfs directory which switches to a HAMT sharded one once
+// its estimated block size reaches shardingSize, the same way as go-unixfs
+// does with its global option, which is left unset
+type shardedDir struct {
+	ufsio.Directory
+	shardingSize int
+	// size is the estimated block size while the directory is not sharded
+	size int
+}
+
+// newShardedDir returns an empty directory, shardingSize 0 never shards it
+func newShardedDir(dagServ format.DAGService, cidBuilder cid.Builder, shardingSize int) (*shardedDir, error) {
+	dir, err := ufsio.NewDirectoryFromNode(dagServ, unixfs.EmptyDirNode())
+	if err != nil {
+		return nil, err
+	}
+	dir.SetCidBuilder(cidBuilder)
+	return &shardedDir{
+		Directory:    dir,
+		shardingSize: shardingSize,
+	}, nil
+}
+
+// AddChild adds nd as name, entries are expected to be added once
+func (d *shardedDir) AddChild(ctx context.Context, name string, nd format.Node) error {
+	if err := d.Directory.AddChild(ctx, name, nd); err != nil {
+		return err
+	}
+	basic, ok := d.Directory.(*ufsio.BasicDirectory)
+	if !ok || d.shardingSize == 0 {
+		return nil
+	}
+	d.size += len(name) + nd.Cid().ByteLen()
+	if d.size < d.shardingSize {
+		return nil
+	}
+	sharded, err := basic.SwitchToSharding(ctx)
+	if err != nil {
+		return err
+	}
+	d.Directory = sharded
+	return nil
+}
 
+// dirBuilder builds unixfs directory dags from a local directory tree
+type dirBuilder struct {
+	dagServ    format.DAGService
+	cidBuilder cid.Builder
+	opts       api.ImportOpts
+	// shardingSize is the HAMT sharding size of the directories
+	shardingSize int
+	pb           *pbar
+	onFile       func(string, cid.Cid)
+}
+
+func (db *dirBuilder) add(ctx context.Context, path string) (format.Node, error) {
+	finfo, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case finfo.IsDir():
+		return db.addDir(ctx, path, finfo)
+	case finfo.Mode()&os.ModeSymlink != 0:
+		return db.addSymlink(ctx, path)
+	case finfo.Mode().IsRegular():
+		return db.addFile(ctx, path)
+	default:
+		return nil, xerrors.Errorf("%s is not a regular file, dir or symlink", path)
+	}
+}
+
+func (db *dirBuilder) addDir(ctx context.Context, path string, finfo os.FileInfo) (format.Node, error) {
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := newShardedDir(db.dagServ, db.cidBuilder, db.shardingSize)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
 		}
-	}(out, iodone, ioerr)
-	go func(iodone chan struct{}, ioerr chan error) {
-		_, err = io.Copy(io.MultiWriter(f, pb), fdr)
+		if !entry.IsDir() && !entry.Mode().IsRegular() && entry.Mode()&os.ModeSymlink == 0 {
+			log.Warnf("ignore %s: not a regular file, dir or symlink", filepath.Join(path, entry.Name()))
+			continue
+		}
+		nd, err := db.add(ctx, filepath.Join(path, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if err := dir.AddChild(ctx, entry.Name(), nd); err != nil {
+			return nil, err
+		}
+	}
+	nd, err := dir.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	if nd, err = withMeta(nd, fileMeta(finfo, db.opts), db.cidBuilder); err != nil {
+		return nil, err
+	}
+	if err := db.dagServ.Add(ctx, nd); err != nil {
+		return nil, err
+	}
+	return nd, nil
+}
+
+func (db *dirBuilder) addSymlink(ctx context.Context, path string) (format.Node, error) {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := unixfs.SymlinkData(target)
+	if err != nil {
+		return nil, err
+	}
+	nd := merkledag.NodeWithData(data)
+	nd.SetCidBuilder(db.cidBuilder)
+	if err := db.dagServ.Add(ctx, nd); err != nil {
+		return nil, err
+	}
+	return nd, nil
+}
+
+func (db *dirBuilder) addFile(ctx context.Context, path string) (format.Node, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	finfo, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	r, err := importReader(path, io.TeeReader(f, db.pb), finfo, db.opts)
+	if err != nil {
+		return nil, err
+	}
+	nd, err := BuildFileNode(ctx, r, db.dagServ, db.opts, fileMeta(finfo, db.opts))
+	if err != nil {
+		return nil, err
+	}
+	if db.onFile != nil {
+		db.onFile(path, nd.Cid())
+	}
+	return nd, nil
+}
+
+// dirSize sums up the size of regular files under path
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
 		if err != nil {
-			ioerr <- err
-			return
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
 		}
-		iodone <- struct{}{}
-	}(iodone, ioerr)
-	return out, err
+		return nil
+	})
+	return size, err
 }
 
 type pbar struct {
@@ -182,13 +638,18 @@ type pbar struct {
 
 func (pb *pbar) Write(p []byte) (n int, err error) {
 	l := len(p)
-	pb.Current += int64(l)
-	if pb.Total > 0 && pb.Current > pb.Total {
-		pb.Current = pb.Total
-	}
+	pb.add(int64(l))
 	return l, nil
 }
 
+// add counts n more bytes, Current is only written by the job and read by
+// the progress ticker concurrently, so both go through atomics
+func (pb *pbar) add(n int64) {
+	if cur := atomic.AddInt64(&pb.Current, n); pb.Total > 0 && cur > pb.Total {
+		atomic.StoreInt64(&pb.Current, pb.Total)
+	}
+}
+
 func (pb *pbar) Done() bool {
 	if pb.Total < 0 {
 		return false
@@ -196,10 +657,61 @@ func (pb *pbar) Done() bool {
 	return pb.Current >= pb.Total
 }
 
-func (a *CommonAPI) Add2(ctx context.Context, path string, br int) (chan api.PBar, error) {
-	// cidbuilder
-	cidBuilder, err := merkledag.PrefixForCidVersion(0)
-	if err != nil {
+// progressTick is how often the progress of a running job is reported
+const progressTick = 50 * time.Millisecond
+
+// runWithProgress runs work in the background and returns the channel
+// streaming pb every progressTick, the reports sent by work and, last, the
+// report work returns. Only this closes the channel, once work returns, and
+// every send gives up once ctx is done, so that work always runs to the end
+// and releases what it holds, even if the client is gone
+func runWithProgress(ctx context.Context, pb *pbar, work func(send func(api.PBar)) api.PBar) chan api.PBar {
+	out := make(chan api.PBar)
+	send := func(p api.PBar) {
+		select {
+		case out <- p:
+		case <-ctx.Done():
+		}
+	}
+	done := make(chan struct{})
+	tickerDone := make(chan struct{})
+	go func() {
+		defer close(tickerDone)
+		tic := time.NewTicker(progressTick)
+		defer tic.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-tic.C:
+				select {
+				case out <- api.PBar{
+					Total:   pb.Total,
+					Current: atomic.LoadInt64(&pb.Current),
+				}:
+				case <-done:
+					return
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	go func() {
+		res := work(send)
+		close(done)
+		<-tickerDone
+		send(res)
+		close(out)
+	}()
+	return out
+}
+
+func (a *CommonAPI) Add2(ctx context.Context, path string, br int, opts api.ImportOpts) (chan api.PBar, error) {
+	// validate options before any work
+	if err := CheckImportOpts(opts); err != nil {
 		return nil, err
 	}
 	finfo, err := os.Stat(path)
@@ -210,66 +722,71 @@ func (a *CommonAPI) Add2(ctx context.Context, path string, br int) (chan api.PBa
 		return nil, xerrors.Errorf("%s is dir, add only works on file", path)
 	}
 	fsize := finfo.Size()
+	if err := a.checkNoCopy(opts); err != nil {
+		return nil, err
+	}
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	// resume from the checkpoint of an interrupted import of the file
+	var cp *importCheckpoint
+	if !opts.OnlyHash {
+		cp, err = openCheckpoint(a.Node.Datastore, path, finfo, opts)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if _, err := f.Seek(int64(cp.offset), io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
 	pb := &pbar{
 		Total: finfo.Size(),
 	}
-	iodone := make(chan struct{})
-	ioerr := make(chan error)
-	out := make(chan api.PBar)
-
-	go func(p chan api.PBar, iodone chan struct{}, ioerr chan error) {
-		defer close(out)
-		tic := time.NewTicker(time.Millisecond * 50)
-		for {
-			select {
-			case <-ctx.Done():
-				out <- api.PBar{
-					Total:   pb.Total,
-					Current: pb.Current,
-					Err:     ctx.Err().Error(),
-				}
-				return
-			case <-iodone:
-				return
-			case <-ioerr:
-				return
-			case <-tic.C:
-				if pb.Done() {
-					out <- api.PBar{
-						Total:   pb.Total,
-						Current: pb.Current,
-					}
-				} else {
-					out <- api.PBar{
-						Total:   pb.Total,
-						Current: pb.Current,
-					}
-				}
-			}
+	if cp != nil {
+		pb.Current = int64(cp.offset)
+	}
+	r, err := importReader(path, io.TeeReader(f, pb), finfo, opts)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	dagServ := a.importDagServ(opts)
+	out := runWithProgress(ctx, pb, func(send func(api.PBar)) api.PBar {
+		defer a.Node.GCLocker.PinLock().Unlock()
+		defer f.Close()
+		if cp != nil && len(cp.links) > 0 {
+			send(api.PBar{
+				Total:   pb.Total,
+				Current: pb.Current,
+				Msg:     fmt.Sprintf("Resume: %d chunks, %d bytes already added", len(cp.links), cp.offset),
+			})
+		}
+		ndcid, err := balanceNode(ctx, r, fsize, dagServ, opts, br, cp, fileMeta(finfo, opts))
+		if err == nil {
+			a.recordImport(ndcid, opts)
+			err = a.pinAdded(ndcid, opts)
 		}
-	}(out, iodone, ioerr)
-	go func(iodone chan struct{}, ioerr chan error) {
-		ndcid, err := importer.BalanceNode(ctx, io.TeeReader(f, pb), fsize, a.Node.Dagserv, cidBuilder, br)
 		if err != nil {
-			ioerr <- err
-			out <- api.PBar{
+			return api.PBar{
 				Total:   pb.Total,
 				Current: pb.Current,
 				Err:     err.Error(),
 				Msg:     fmt.Sprintf("Add Failed: %s", err),
 			}
-			return
 		}
-		out <- api.PBar{
+		if cp != nil {
+			if err := cp.remove(); err != nil {
+				log.Warnf("remove checkpoint of %s: %s", path, err)
+			}
+		}
+		return api.PBar{
 			Total:   pb.Total,
 			Current: pb.Total,
-			Msg:     fmt.Sprintf("Add Success: %s", ndcid),
+			Msg:     addSuccessMsg(ndcid, dagServ),
 		}
-		iodone <- struct{}{}
-	}(iodone, ioerr)
-	return out, err
+	})
+	return out, nil
 }
@@ -0,0 +1,245 @@
+package impl
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/filedrive-team/filejoy/api"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	ufs "github.com/ipfs/go-unixfs"
+	ufsio "github.com/ipfs/go-unixfs/io"
+	pb "github.com/ipfs/go-unixfs/pb"
+)
+
+func TestAddDirSharding(t *testing.T) {
+	n := newTestNode(t)
+	dir := t.TempDir()
+	for i := 0; i < 40; i++ {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file-%02d", i)), randData(int64(i), 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cidBuilder, err := NewCidBuilder(0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addDir := func(shardingSize int) format.Node {
+		t.Helper()
+		db := &dirBuilder{
+			dagServ:      n.Dagserv,
+			cidBuilder:   cidBuilder,
+			opts:         smallChunks,
+			shardingSize: shardingSize,
+			pb:           &pbar{},
+		}
+		nd, err := db.add(context.Background(), dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return nd
+	}
+	dirType := func(nd format.Node) pb.Data_DataType {
+		t.Helper()
+		fsn, err := ufs.FSNodeFromBytes(nd.(*merkledag.ProtoNode).Data())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fsn.Type()
+	}
+
+	// each entry is about 41 bytes
+	if nd := addDir(40 * 41 * 2); dirType(nd) != ufs.TDirectory {
+		t.Errorf("small directory sharded")
+	}
+	if nd := addDir(0); dirType(nd) != ufs.TDirectory {
+		t.Errorf("directory sharded with no sharding size")
+	}
+	sharded := addDir(1000)
+	if dirType(sharded) != ufs.THAMTShard {
+		t.Fatalf("large directory not sharded")
+	}
+	if ufsio.HAMTShardingSize != 0 {
+		t.Errorf("global sharding size set to %d", ufsio.HAMTShardingSize)
+	}
+
+	// the same directory as go-unixfs builds with its global option
+	ufsio.HAMTShardingSize = 1000
+	defer func() { ufsio.HAMTShardingSize = 0 }()
+	expected := ufsio.NewDirectory(n.Dagserv)
+	expected.SetCidBuilder(cidBuilder)
+	for i := 0; i < 40; i++ {
+		nd, err := BuildFileNode(context.Background(), bytes.NewReader(randData(int64(i), 100)), n.Dagserv, smallChunks, FileMeta{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := expected.AddChild(context.Background(), fmt.Sprintf("file-%02d", i), nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectedNd, err := expected.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sharded.Cid().Equals(expectedNd.Cid()) {
+		t.Errorf("sharded directory %s, go-unixfs builds %s", sharded.Cid(), expectedNd.Cid())
+	}
+}
+
+func TestGetCancelled(t *testing.T) {
+	n := newTestNode(t)
+	a := &CommonAPI{Node: n}
+	c := addTestFile(t, n, randData(1, 256<<10), smallChunks)
+	// let the goroutines of the import end
+	time.Sleep(100 * time.Millisecond)
+	before := runtime.NumGoroutine()
+	// the client is gone before reading any progress
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := a.Get(ctx, c, filepath.Join(t.TempDir(), "out"), api.GetOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	for deadline := time.Now().Add(5 * time.Second); runtime.NumGoroutine() > before; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines left running after the client was gone", runtime.NumGoroutine()-before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCatEndsWithEOF(t *testing.T) {
+	n := newTestNode(t)
+	a := &CommonAPI{Node: n}
+	data := randData(1, catChunkSize+10<<10)
+	c := addTestFile(t, n, data, smallChunks)
+	for _, tc := range []struct {
+		offset, length int64
+		expected       []byte
+	}{
+		{0, 0, data},
+		{100, 0, data[100:]},
+		{100, 1000, data[100:1100]},
+		{int64(len(data)), 0, nil},
+	} {
+		chunks, err := a.Cat(context.Background(), c, tc.offset, tc.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []byte
+		var last api.CatChunk
+		for chunk := range chunks {
+			if last.EOF {
+				t.Fatalf("cat at %d: chunk after the end of the stream", tc.offset)
+			}
+			if chunk.Err != "" {
+				t.Fatalf("cat at %d: %s", tc.offset, chunk.Err)
+			}
+			got = append(got, chunk.Data...)
+			last = chunk
+		}
+		if !last.EOF {
+			t.Errorf("cat at %d length %d: the stream has no end marker", tc.offset, tc.length)
+		}
+		if !bytes.Equal(got, tc.expected) {
+			t.Errorf("cat at %d length %d: got %d bytes, expected %d", tc.offset, tc.length, len(got), len(tc.expected))
+		}
+	}
+}
+
+func TestGetTar(t *testing.T) {
+	n := newTestNode(t)
+	a := &CommonAPI{Node: n}
+	dir := filepath.Join(t.TempDir(), "dir")
+	files := map[string][]byte{
+		"a":     randData(1, catChunkSize+100),
+		"sub/b": randData(2, 10<<10),
+	}
+	for name, data := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db := &dirBuilder{
+		dagServ:    n.Dagserv,
+		cidBuilder: cid.V0Builder{},
+		opts:       smallChunks,
+		pb:         &pbar{},
+	}
+	nd, err := db.add(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := nd.Cid()
+
+	chunks, err := a.GetTar(context.Background(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var archive bytes.Buffer
+	var last api.CatChunk
+	for chunk := range chunks {
+		if chunk.Err != "" {
+			t.Fatal(chunk.Err)
+		}
+		archive.Write(chunk.Data)
+		last = chunk
+	}
+	if !last.EOF {
+		t.Fatal("the tar stream has no end marker")
+	}
+	tr := tar.NewReader(&archive)
+	got := make(map[string][]byte)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[strings.TrimPrefix(hdr.Name, c.String()+"/")] = data
+	}
+	if len(got) != len(files) {
+		t.Fatalf("archive has %d files, expected %d", len(got), len(files))
+	}
+	for name, data := range files {
+		if !bytes.Equal(got[name], data) {
+			t.Errorf("archived %s differs", name)
+		}
+	}
+
+	// the writer stops once the client is gone
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := a.GetTar(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	for deadline := time.Now().Add(5 * time.Second); runtime.NumGoroutine() > before; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines left running after the client was gone", runtime.NumGoroutine()-before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
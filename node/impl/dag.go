@@ -19,8 +19,11 @@ import (
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	format "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+	pb "github.com/ipfs/go-unixfs/pb"
 	gocar "github.com/ipld/go-car"
 	carutil "github.com/ipld/go-car/util"
+	"golang.org/x/xerrors"
 )
 
 type DagAPI struct {
@@ -46,6 +49,104 @@ func (a *DagAPI) DagStat(ctx context.Context, cid cid.Cid, timeout uint) (*forma
 	return stat, nil
 }
 
+// DagStatRecursive walks the dag of c and sums up the stats of its unique
+// blocks, blocks which can not be loaded are counted as missing
+func (a *DagAPI) DagStatRecursive(ctx context.Context, c cid.Cid, opts api.DagStatOpts) (*api.DagStats, error) {
+	var mu sync.Mutex
+	stats := &api.DagStats{}
+	err := walkDag(ctx, a.nodeGetter(!opts.Offline), []cid.Cid{c}, opts.Concurrent, time.Duration(opts.Timeout)*time.Second, func(c cid.Cid, depth int, nd format.Node, err error) error {
+		if err != nil {
+			if !isMissing(err) {
+				return xerrors.Errorf("load %s: %w", c, err)
+			}
+			mu.Lock()
+			stats.Missing++
+			mu.Unlock()
+			return nil
+		}
+		data, err := fileData(nd)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		stats.Blocks++
+		stats.Bytes += uint64(len(nd.RawData()))
+		stats.FileSize += data
+		if depth > stats.MaxDepth {
+			stats.MaxDepth = depth
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// dagCheckConcurrency is the number of blocks DagCheck reads at a time
+const dagCheckConcurrency = 16
+
+// DagCheck walks the dag of c in the local blockstore, without fetching
+// anything from the network, and reports the blocks missing from it. Up to
+// limit missing cids are returned
+func (a *DagAPI) DagCheck(ctx context.Context, c cid.Cid, limit int) (*api.DagCheckResult, error) {
+	var mu sync.Mutex
+	res := &api.DagCheckResult{}
+	err := walkDag(ctx, a.nodeGetter(false), []cid.Cid{c}, dagCheckConcurrency, 0, func(c cid.Cid, _ int, _ format.Node, err error) error {
+		if err != nil && !isMissing(err) {
+			return xerrors.Errorf("load %s: %w", c, err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if err == nil {
+			res.Blocks++
+			return nil
+		}
+		res.Missing++
+		if len(res.MissingCids) < limit {
+			res.MissingCids = append(res.MissingCids, c)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	res.Complete = res.Missing == 0
+	return res, nil
+}
+
+// fileData returns the size of the unixfs file data held by nd itself
+func fileData(nd format.Node) (uint64, error) {
+	switch n := nd.(type) {
+	case *merkledag.RawNode:
+		return uint64(len(n.RawData())), nil
+	case *merkledag.ProtoNode:
+		fsn, err := unixfs.FSNodeFromBytes(n.Data())
+		if err != nil {
+			// not unixfs
+			return 0, nil
+		}
+		if fsn.Type() == pb.Data_File || fsn.Type() == pb.Data_Raw {
+			return uint64(len(fsn.Data())), nil
+		}
+	}
+	return 0, nil
+}
+
+// nodeGetter returns a getter of the dag service, or of the local
+// blockstore only if not online
+func (a *DagAPI) nodeGetter(online bool) format.NodeGetter {
+	if online {
+		return &onlineng{
+			ng: a.Node.Dagserv,
+		}
+	}
+	return &offlineng{
+		ng: a.Node.Blockstore,
+	}
+}
+
 // func (a *DagAPI) DagSync(ctx context.Context, cids []cid.Cid, concur int) (chan string, error) {
 // 	var concurOption merkledag.WalkOption = merkledag.Concurrent()
 // 	if concur > 32 {
@@ -75,23 +176,51 @@ func (a *DagAPI) DagStat(ctx context.Context, cid cid.Cid, timeout uint) (*forma
 // 	return out, nil
 // }
 
-func (a *DagAPI) DagSync(ctx context.Context, cids []cid.Cid, concur int) (chan string, error) {
+// DagSync fetches the dags of cids, the roots are pinned recursively once
+// all of their blocks are fetched if pin is set
+func (a *DagAPI) DagSync(ctx context.Context, cids []cid.Cid, concur int, pin *api.PinOpts) (chan string, error) {
 	if concur <= 0 {
 		concur = 1
 	}
 	out := make(chan string)
 	doneSignal := make(chan struct{})
 	cidsToLoad := make(chan cid.Cid)
-	dagServ := merkledag.NewDAGService(blockservice.New(a.Node.Blockstore, a.Node.Bitswap))
+	dagServ := &quotaGetter{
+		NodeGetter: merkledag.NewDAGService(blockservice.New(a.Node.Blockstore, a.Node.Bitswap)),
+		bs:         a.Node.Blockstore,
+		q:          a.Node.Quota,
+	}
 	totalCids := int32(len(cids))
 	numLoaded := int32(0)
+	failed := int32(0)
 	var cds sync.Once
 	syncDone := func() {
 		cds.Do(func() {
 			close(doneSignal)
 		})
 	}
+	// every send gives up once ctx is done, so that the pin lock is
+	// released even if the client is gone
+	send := func(msg string) {
+		select {
+		case out <- msg:
+		case <-ctx.Done():
+		}
+	}
+	// feed queues the cids to load until the sync is done or cancelled
+	feed := func(cs []cid.Cid) {
+		for _, c := range cs {
+			select {
+			case cidsToLoad <- c:
+			case <-doneSignal:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
 	go func() {
+		defer a.Node.GCLocker.PinLock().Unlock()
 		defer close(out)
 		var wg sync.WaitGroup
 		wg.Add(concur)
@@ -101,7 +230,7 @@ func (a *DagAPI) DagSync(ctx context.Context, cids []cid.Cid, concur int) (chan
 				for {
 					select {
 					case <-ctx.Done():
-						out <- ctx.Err().Error()
+						send(ctx.Err().Error())
 						//log.Info("context done")
 						return
 					case <-doneSignal:
@@ -116,7 +245,8 @@ func (a *DagAPI) DagSync(ctx context.Context, cids []cid.Cid, concur int) (chan
 							// try get dag one more time
 							nd, err = dagServ.Get(ctx, cc)
 							if err != nil {
-								out <- fmt.Sprintf("Failed to get %s, error: %s", cc, err)
+								send(fmt.Sprintf("Failed to get %s, error: %s", cc, err))
+								atomic.AddInt32(&failed, 1)
 							} else {
 								success = true
 							}
@@ -130,13 +260,13 @@ func (a *DagAPI) DagSync(ctx context.Context, cids []cid.Cid, concur int) (chan
 							//log.Infof("new links: %d", numlink)
 							if numlink > 0 {
 								atomic.AddInt32(&totalCids, int32(numlink))
-								go func() {
-									for _, link := range links {
-										cidsToLoad <- link.Cid
-									}
-								}()
+								lcids := make([]cid.Cid, 0, numlink)
+								for _, link := range links {
+									lcids = append(lcids, link.Cid)
+								}
+								go feed(lcids)
 							}
-							out <- nd.Cid().String()
+							send(nd.Cid().String())
 						}
 						atomic.AddInt32(&numLoaded, 1)
 						nl := atomic.LoadInt32(&numLoaded)
@@ -152,16 +282,44 @@ func (a *DagAPI) DagSync(ctx context.Context, cids []cid.Cid, concur int) (chan
 			}(i)
 		}
 		wg.Wait()
-	}()
-	go func() {
+		if pin == nil || ctx.Err() != nil {
+			return
+		}
+		if atomic.LoadInt32(&failed) > 0 {
+			send("Not pinned, some blocks could not be fetched")
+			return
+		}
 		for _, cc := range cids {
-			cidsToLoad <- cc
+			if err := pinRoot(a.Node, cc, pin); err != nil {
+				send(fmt.Sprintf("Failed to pin %s, error: %s", cc, err))
+				continue
+			}
+			send(fmt.Sprintf("pinned %s", cc))
 		}
 	}()
+	go feed(cids)
 
 	return out, nil
 }
 
+// quotaGetter fails the gets of the blocks missing locally once the storage
+// max is reached, bitswap drops the fetched blocks it can not store and the
+// gets would never end
+type quotaGetter struct {
+	format.NodeGetter
+	bs blockstore.Blockstore
+	q  *node.Quota
+}
+
+func (g *quotaGetter) Get(ctx context.Context, c cid.Cid) (format.Node, error) {
+	if has, err := g.bs.Has(c); err != nil || !has {
+		if err := g.q.Check(0); err != nil {
+			return nil, err
+		}
+	}
+	return g.NodeGetter.Get(ctx, c)
+}
+
 type onlineng struct {
 	ng format.DAGService
 }
@@ -290,7 +448,9 @@ func (a *DagAPI) DagExport(ctx context.Context, c cid.Cid, path string, pad bool
 	return out, err
 }
 
-func (a *DagAPI) DagImport(ctx context.Context, targetPath string) (chan api.PBar, error) {
+// DagImport stores the blocks of the car file at targetPath, the roots of
+// the car are pinned recursively if pin is set
+func (a *DagAPI) DagImport(ctx context.Context, targetPath string, pin *api.PinOpts) (chan api.PBar, error) {
 	finfo, err := os.Stat(targetPath)
 	if err != nil {
 		return nil, err
@@ -299,91 +459,54 @@ func (a *DagAPI) DagImport(ctx context.Context, targetPath string) (chan api.PBa
 	pb := &pbar{
 		Total: finfo.Size(),
 	}
-	iodone := make(chan struct{})
-	ioerr := make(chan error)
-	out := make(chan api.PBar)
-	closeOut := sync.Once{}
-	closefunc := func() {
-		closeOut.Do(func() {
-			close(out)
-		})
-	}
-	go func(p chan api.PBar, iodone chan struct{}, ioerr chan error) {
-		tic := time.NewTicker(time.Millisecond * 50)
-		for {
-			select {
-			case <-ctx.Done():
-				out <- api.PBar{
-					Total:   pb.Total,
-					Current: pb.Current,
-				}
-				closefunc()
-				return
-			case <-iodone:
-				out <- api.PBar{
-					Total:   pb.Total,
-					Current: pb.Total,
-				}
-				closefunc()
-				return
-			case e := <-ioerr:
-				out <- api.PBar{
-					Total:   pb.Total,
-					Current: pb.Current,
-					Err:     e.Error(),
-				}
-				closefunc()
-			case <-tic.C:
-				if pb.Done() {
-					out <- api.PBar{
-						Total:   pb.Total,
-						Current: pb.Current,
-					}
-					return
-				}
-				out <- api.PBar{
-					Total:   pb.Total,
-					Current: pb.Current,
-				}
+	out := runWithProgress(ctx, pb, func(send func(api.PBar)) api.PBar {
+		defer a.Node.GCLocker.PinLock().Unlock()
+		fail := func(err error) api.PBar {
+			return api.PBar{
+				Total:   pb.Total,
+				Current: pb.Current,
+				Err:     err.Error(),
 			}
-
 		}
-	}(out, iodone, ioerr)
-	go func(iodone chan struct{}, ioerr chan error) {
 		f, err := os.OpenFile(targetPath, os.O_RDWR, 0644)
 		if err != nil {
-			ioerr <- err
-			return
+			return fail(err)
 		}
 		defer f.Close()
 
 		br := bufio.NewReader(io.TeeReader(f, pb))
-		_, err = gocar.ReadHeader(br)
+		hd, err := gocar.ReadHeader(br)
 		if err != nil {
-			ioerr <- err
-			return
+			return fail(err)
 		}
 		for {
+			if err := ctx.Err(); err != nil {
+				return fail(err)
+			}
 			cid, data, err := carutil.ReadNode(br)
 			if err != nil {
 				if err == io.EOF {
-					iodone <- struct{}{}
 					break
 				}
-				ioerr <- err
-				return
+				return fail(err)
 			}
 			bn, err := blocks.NewBlockWithCid(data, cid)
 			if err != nil {
-				ioerr <- err
-				return
+				return fail(err)
 			}
 			if err = a.Node.Blockstore.Put(bn); err != nil {
-				ioerr <- err
-				return
+				return fail(err)
 			}
 		}
-		iodone <- struct{}{}
-	}(iodone, ioerr)
-	return out, err
+		for _, root := range hd.Roots {
+			if err := pinRoot(a.Node, root, pin); err != nil {
+				return fail(err)
+			}
+		}
+		return api.PBar{
+			Total:   pb.Total,
+			Current: pb.Total,
+		}
+	})
+	return out, nil
 }
@@ -0,0 +1,194 @@
+package impl
+
+import (
+	"context"
+	"time"
+
+	"github.com/filedrive-team/filejoy/api"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	format "github.com/ipfs/go-ipld-format"
+	legacy "github.com/ipfs/go-ipld-legacy"
+	"golang.org/x/xerrors"
+)
+
+// block status reported by DagVerify
+const (
+	BlockOK          = "ok"
+	BlockMissing     = "missing"
+	BlockMismatch    = "mismatch"
+	BlockUndecodable = "undecodable"
+	BlockError       = "error"
+)
+
+// blockProblem is the error of a block which failed verification
+type blockProblem struct {
+	status string
+	err    error
+	// repaired is set if the block was fetched again and is now good
+	repaired bool
+}
+
+func (bp *blockProblem) Error() string {
+	return bp.status + ": " + bp.err.Error()
+}
+
+func (bp *blockProblem) Unwrap() error {
+	return bp.err
+}
+
+// blockVerifier is a node getter which rehashes the blocks it reads from
+// the blockstore. Bad blocks are fetched again from the network if fetch is
+// set, and the bad block is replaced once the fetched one is checked, its
+// data is kept in the quarantine of ds
+type blockVerifier struct {
+	bs    blockstore.Blockstore
+	fetch blockFetcher
+	ds    datastore.Datastore
+}
+
+// blockFetcher gets blocks from the network, unlike a block service it does
+// not return the bad local copy
+type blockFetcher interface {
+	GetBlock(context.Context, cid.Cid) (blocks.Block, error)
+}
+
+func (bv *blockVerifier) Get(ctx context.Context, c cid.Cid) (format.Node, error) {
+	nd, bp := bv.check(ctx, c)
+	if bp == nil {
+		return nd, nil
+	}
+	if bv.fetch == nil || bp.status == BlockMissing || bp.status == BlockError {
+		return nil, bp
+	}
+	log.Warnf("repairing %s block %s", bp.status, c)
+	repairErr := func(what string, err error) error {
+		return &blockProblem{status: bp.status, err: xerrors.Errorf("%s, %s: %w", bp.err, what, err)}
+	}
+	// the bad block is only touched once a good one is at hand
+	blk, err := bv.fetch.GetBlock(ctx, c)
+	if err != nil {
+		if ctx.Err() != nil {
+			// fetch errors do not always wrap the context error
+			err = ctx.Err()
+		}
+		return nil, repairErr("fetch for repair", err)
+	}
+	if sum, err := c.Prefix().Sum(blk.RawData()); err != nil {
+		return nil, repairErr("fetch for repair", err)
+	} else if !sum.Equals(c) {
+		return nil, repairErr("fetch for repair", xerrors.Errorf("fetched data hashes to %s", sum))
+	}
+	bad, err := bv.bs.Get(c)
+	if err != nil {
+		return nil, repairErr("read for quarantine", err)
+	}
+	if err := bv.ds.Put(fsckQuarantinePrefix.ChildString(c.String()), bad.RawData()); err != nil {
+		return nil, repairErr("quarantine", err)
+	}
+	if err := bv.bs.DeleteBlock(c); err != nil {
+		return nil, repairErr("delete for repair", err)
+	}
+	if err := bv.bs.Put(blk); err != nil {
+		return nil, repairErr("store repaired block, the bad one is in the quarantine", err)
+	}
+	nd, rbp := bv.check(ctx, c)
+	if rbp != nil {
+		return nil, repairErr("repaired block", rbp)
+	}
+	bp.repaired = true
+	return nd, bp
+}
+
+func (bv *blockVerifier) GetMany(ctx context.Context, cids []cid.Cid) <-chan *format.NodeOption {
+	out := make(chan *format.NodeOption, len(cids))
+	defer close(out)
+	for _, c := range cids {
+		nd, err := bv.Get(ctx, c)
+		out <- &format.NodeOption{Node: nd, Err: err}
+	}
+	return out
+}
+
+// check reads c from the blockstore, rehashes and decodes it
+func (bv *blockVerifier) check(ctx context.Context, c cid.Cid) (format.Node, *blockProblem) {
+	blk, err := bv.bs.Get(c)
+	if err != nil {
+		if xerrors.Is(err, blockstore.ErrNotFound) {
+			return nil, &blockProblem{status: BlockMissing, err: err}
+		}
+		return nil, &blockProblem{status: BlockError, err: err}
+	}
+	sum, err := c.Prefix().Sum(blk.RawData())
+	if err != nil {
+		return nil, &blockProblem{status: BlockError, err: err}
+	}
+	if !sum.Equals(c) {
+		return nil, &blockProblem{status: BlockMismatch, err: xerrors.Errorf("data hashes to %s", sum)}
+	}
+	// decode the checked data, the blockstore could return other data on
+	// another read
+	blk, err = blocks.NewBlockWithCid(blk.RawData(), c)
+	if err != nil {
+		return nil, &blockProblem{status: BlockError, err: err}
+	}
+	nd, err := legacy.DecodeNode(ctx, blk)
+	if err != nil {
+		return nil, &blockProblem{status: BlockUndecodable, err: err}
+	}
+	return nd, nil
+}
+
+// DagVerify walks the dag of c in the local blockstore and rehashes every
+// block, the status of each block is streamed. With repair, mismatched and
+// undecodable blocks are fetched again from the network, the bad data is
+// moved to the quarantine of repo fsck
+func (a *DagAPI) DagVerify(ctx context.Context, c cid.Cid, opts api.DagVerifyOpts) (chan api.DagVerifyRef, error) {
+	bv := &blockVerifier{
+		bs: a.Node.Blockstore,
+	}
+	if opts.Repair {
+		bv.fetch = a.Node.Bitswap
+		bv.ds = a.Node.Datastore
+	}
+	out := make(chan api.DagVerifyRef)
+	go func() {
+		defer close(out)
+		send := func(ref api.DagVerifyRef) error {
+			select {
+			case out <- ref:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		err := walkDag(ctx, bv, []cid.Cid{c}, opts.Concurrent, time.Duration(opts.Timeout)*time.Second, func(c cid.Cid, _ int, _ format.Node, err error) error {
+			ref := api.DagVerifyRef{
+				Cid:    c,
+				Status: BlockOK,
+			}
+			var bp *blockProblem
+			if xerrors.As(err, &bp) {
+				ref.Status = bp.status
+				ref.Repaired = bp.repaired
+				if !bp.repaired {
+					ref.Err = bp.Error()
+				}
+			} else if err != nil {
+				ref.Status = BlockError
+				ref.Err = err.Error()
+			}
+			return send(ref)
+		})
+		if err != nil && ctx.Err() == nil {
+			send(api.DagVerifyRef{
+				Cid:    c,
+				Status: BlockError,
+				Err:    err.Error(),
+			})
+		}
+	}()
+	return out, nil
+}
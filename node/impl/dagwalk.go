@@ -0,0 +1,121 @@
+package impl
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	format "github.com/ipfs/go-ipld-format"
+	"golang.org/x/xerrors"
+)
+
+// walkItem is a block to visit and its depth below the walk roots
+type walkItem struct {
+	c     cid.Cid
+	depth int
+}
+
+// walkFunc is called for every block of a walk, nd is nil and err is set
+// if the block could not be loaded. Returning an error stops the walk
+type walkFunc func(c cid.Cid, depth int, nd format.Node, err error) error
+
+// walkDag visits every unique block under roots once, with up to concur
+// blocks loaded at a time. Each load gets timeout if it is positive. visit
+// is called concurrently, the links of loaded blocks are followed unless
+// visit fails
+func walkDag(ctx context.Context, ng format.NodeGetter, roots []cid.Cid, concur int, timeout time.Duration, visit walkFunc) error {
+	if concur <= 0 {
+		concur = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu   sync.Mutex
+		cond = sync.NewCond(&mu)
+		seen = cid.NewSet()
+		// queue is used as a stack, walking depth first keeps it short
+		queue []walkItem
+		// pending counts the blocks queued or being visited
+		pending int
+		werr    error
+	)
+	push := func(c cid.Cid, depth int) {
+		if seen.Visit(c) {
+			queue = append(queue, walkItem{c: c, depth: depth})
+			pending++
+		}
+	}
+	for _, c := range roots {
+		push(c, 0)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(concur)
+	for i := 0; i < concur; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				mu.Lock()
+				for len(queue) == 0 && pending > 0 && werr == nil {
+					cond.Wait()
+				}
+				if pending == 0 || werr != nil {
+					mu.Unlock()
+					return
+				}
+				item := queue[len(queue)-1]
+				queue = queue[:len(queue)-1]
+				mu.Unlock()
+
+				nd, err := loadNode(ctx, ng, item.c, timeout)
+				if ctx.Err() == nil {
+					err = visit(item.c, item.depth, nd, err)
+				} else {
+					err = ctx.Err()
+				}
+
+				mu.Lock()
+				if err != nil && werr == nil {
+					werr = err
+					cancel()
+				}
+				if err == nil && nd != nil {
+					for _, l := range nd.Links() {
+						push(l.Cid, item.depth+1)
+					}
+				}
+				pending--
+				cond.Broadcast()
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return werr
+}
+
+// loadNode gets c from ng, giving up after timeout if it is positive
+func loadNode(ctx context.Context, ng format.NodeGetter, c cid.Cid, timeout time.Duration) (format.Node, error) {
+	if timeout <= 0 {
+		return ng.Get(ctx, c)
+	}
+	tctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	nd, err := ng.Get(tctx, c)
+	if err != nil && ctx.Err() == nil && tctx.Err() != nil && !xerrors.Is(err, context.DeadlineExceeded) {
+		// the fetch errors do not always wrap the deadline
+		return nil, xerrors.Errorf("get %s: %w", c, tctx.Err())
+	}
+	return nd, err
+}
+
+// isMissing tells whether err means a block is not available, locally or
+// from the network within the timeout
+func isMissing(err error) bool {
+	return xerrors.Is(err, format.ErrNotFound) ||
+		xerrors.Is(err, blockstore.ErrNotFound) ||
+		xerrors.Is(err, context.DeadlineExceeded)
+}
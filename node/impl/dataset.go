@@ -0,0 +1,274 @@
+package impl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/filedrive-team/filehelper"
+	"github.com/filedrive-team/filejoy/api"
+	"github.com/filedrive-team/filejoy/node/pinner"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"golang.org/x/xerrors"
+)
+
+const datasetRecordJSON = "record.json"
+const datasetRecordCSV = "record.csv"
+
+// DatasetRecord is the import record of a dataset file
+type DatasetRecord struct {
+	Path string `json:"path"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	CID  string `json:"cid"`
+	// Opts identifies the import options of CID, records written before it
+	// was added have the default options
+	Opts string `json:"opts,omitempty"`
+}
+
+// datasetOptsKey identifies the import options which change the imported
+// dag or how its blocks are stored
+func datasetOptsKey(opts api.ImportOpts) (string, error) {
+	opts.Pin = nil
+	opts.OnlyHash = false
+	b, err := json.Marshal(opts)
+	if err != nil {
+		return "", err
+	}
+	return hashKey(b), nil
+}
+
+// ImportDataset imports every file under targets into bs, the imported files
+// are recorded in recordDir, so that an interrupted import can be continued.
+// Files recorded with other import options are imported again. The recorded
+// files are pinned with pins if opts.Pin is set
+func ImportDataset(ctx context.Context, bs bstore.Blockstore, pins *pinner.Pinner, opts api.ImportOpts, parallel, batchReadNum int, prefix, recordDir string, targets []string) error {
+	// checkout if record dir exists
+	rdinfo, err := os.Stat(recordDir)
+	if err != nil {
+		return err
+	}
+	if !rdinfo.IsDir() {
+		return xerrors.New("record dir is not a dir!")
+	}
+	if err := CheckImportOpts(opts); err != nil {
+		return err
+	}
+
+	optsKey, err := datasetOptsKey(opts)
+	if err != nil {
+		return err
+	}
+	recordPath := path.Join(recordDir, datasetRecordJSON)
+	// check if record.json has data
+	records, err := readDatasetRecords(recordPath)
+	if err != nil {
+		return err
+	}
+	// set up a goroutine to receive csv record line by line
+	csvf, err := os.OpenFile(path.Join(recordDir, datasetRecordCSV), os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer csvf.Close()
+	csvChan := make(chan string)
+	csvDone := make(chan struct{})
+	go func() {
+		defer close(csvDone)
+		for record := range csvChan {
+			if _, err := csvf.WriteString(record); err != nil {
+				log.Error(err)
+			}
+		}
+	}()
+
+	dagServ := merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+
+	var totalFiles uint64
+	var totalSize uint64
+	var importedSize uint64
+	go func() {
+		for _, target := range targets {
+			filepath.Walk(target, func(_ string, fi os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if !fi.IsDir() && fi.Size() > 0 {
+					atomic.AddUint64(&totalFiles, 1)
+					atomic.AddUint64(&totalSize, uint64(fi.Size()))
+				}
+				return nil
+			})
+		}
+	}()
+
+	pchan := make(chan struct{}, parallel)
+	wg := sync.WaitGroup{}
+	lock := sync.RWMutex{}
+	var ferr error
+	files := filehelper.FileWalkAsync(targets)
+	for item := range files {
+		wg.Add(1)
+		go func(item filehelper.Finfo) {
+			defer func() {
+				<-pchan
+				wg.Done()
+			}()
+			pchan <- struct{}{}
+
+			if item.Info.Size() == 0 {
+				return
+			}
+
+			// ignore file which has been imported with the same options
+			lock.RLock()
+			if r, ok := records[item.Path]; ok && r.Opts == optsKey {
+				lock.RUnlock()
+				return
+			}
+			lock.RUnlock()
+
+			fileNodeCid, err := importDatasetFile(ctx, item, dagServ, opts, batchReadNum)
+			if err != nil {
+				lock.Lock()
+				ferr = err
+				lock.Unlock()
+				return
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			records[item.Path] = &DatasetRecord{
+				Path: item.Path,
+				Name: item.Name,
+				Size: item.Info.Size(),
+				CID:  fileNodeCid.String(),
+				Opts: optsKey,
+			}
+
+			imported := atomic.AddUint64(&importedSize, uint64(item.Info.Size()))
+			if tf, ts := atomic.LoadUint64(&totalFiles), atomic.LoadUint64(&totalSize); ts > 0 {
+				fmt.Printf("total %d files, imported %d files, %.2f %%\n", tf, len(records), float64(len(records))/float64(tf)*100)
+				fmt.Printf("total size: %d, imported size: %d, %.2f %%\n", ts, imported, float64(imported)/float64(ts)*100)
+			}
+			csvChan <- fmt.Sprintf("%s,%s,%d\n", strings.TrimPrefix(item.Path, prefix), fileNodeCid.String(), item.Info.Size())
+		}(item)
+	}
+	wg.Wait()
+	close(csvChan)
+	<-csvDone
+	if err := saveDatasetRecords(records, recordPath); err != nil {
+		ferr = err
+	}
+	if opts.Pin != nil {
+		// the files recorded by earlier runs too
+		for _, r := range records {
+			c, err := cid.Decode(r.CID)
+			if err == nil {
+				err = pinCid(pins, c, opts.Pin)
+			}
+			if err != nil {
+				return xerrors.Errorf("pin %s: %w", r.Path, err)
+			}
+		}
+	}
+	return ferr
+}
+
+// HashDataset computes the cids of the files under targets without storing
+// any block, the results are printed in the same format as record.csv
+func HashDataset(ctx context.Context, opts api.ImportOpts, parallel, batchReadNum int, prefix string, targets []string) error {
+	if err := CheckImportOpts(opts); err != nil {
+		return err
+	}
+	dagServ := NewDiscardDAG()
+
+	pchan := make(chan struct{}, parallel)
+	wg := sync.WaitGroup{}
+	lock := sync.Mutex{}
+	var ferr error
+	files := filehelper.FileWalkAsync(targets)
+	for item := range files {
+		wg.Add(1)
+		go func(item filehelper.Finfo) {
+			defer func() {
+				<-pchan
+				wg.Done()
+			}()
+			pchan <- struct{}{}
+
+			if item.Info.Size() == 0 {
+				return
+			}
+			fileNodeCid, err := importDatasetFile(ctx, item, dagServ, opts, batchReadNum)
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				ferr = err
+				return
+			}
+			fmt.Printf("%s,%s,%d\n", strings.TrimPrefix(item.Path, prefix), fileNodeCid.String(), item.Info.Size())
+		}(item)
+	}
+	wg.Wait()
+	fmt.Printf("would store %d blocks, %d bytes\n", dagServ.Blocks(), dagServ.Bytes())
+	return ferr
+}
+
+func importDatasetFile(ctx context.Context, item filehelper.Finfo, dagServ format.DAGService, opts api.ImportOpts, batchReadNum int) (cid.Cid, error) {
+	f, err := os.Open(item.Path)
+	if err != nil {
+		return cid.Undef, err
+	}
+	defer f.Close()
+	log.Infof("import file: %s", item.Path)
+	r, err := importReader(item.Path, f, item.Info, opts)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return balanceNode(ctx, r, item.Info.Size(), dagServ, opts, batchReadNum, nil, fileMeta(item.Info, opts))
+}
+
+func readDatasetRecords(path string) (map[string]*DatasetRecord, error) {
+	res := make(map[string]*DatasetRecord)
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return res, nil
+		}
+		return nil, err
+	}
+
+	if err = json.Unmarshal(bs, &res); err != nil {
+		return nil, err
+	}
+	defaultKey, err := datasetOptsKey(api.ImportOpts{})
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range res {
+		if r.Opts == "" {
+			r.Opts = defaultKey
+		}
+	}
+	return res, nil
+}
+
+func saveDatasetRecords(records map[string]*DatasetRecord, path string) error {
+	bs, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, bs, 0666)
+}
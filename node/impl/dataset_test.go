@@ -0,0 +1,87 @@
+package impl
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/filedrive-team/filejoy/api"
+	"github.com/ipfs/go-cid"
+)
+
+func TestImportDatasetRecordsOpts(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "data")
+	if err := os.WriteFile(file, randData(1, 10<<10), 0644); err != nil {
+		t.Fatal(err)
+	}
+	recordDir := t.TempDir()
+	importDataset := func(opts api.ImportOpts) (*DatasetRecord, int) {
+		t.Helper()
+		n := newTestNode(t)
+		if err := ImportDataset(context.Background(), n.Blockstore, n.Pinner, opts, 1, 4, dir, recordDir, []string{dir}); err != nil {
+			t.Fatal(err)
+		}
+		records, err := readDatasetRecords(path.Join(recordDir, datasetRecordJSON))
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys, err := n.Blockstore.AllKeysChan(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored := 0
+		for range keys {
+			stored++
+		}
+		return records[file], stored
+	}
+
+	v0, stored := importDataset(smallChunks)
+	if stored == 0 {
+		t.Fatal("nothing imported")
+	}
+	// the recorded file is skipped
+	if r, stored := importDataset(smallChunks); stored != 0 || r.CID != v0.CID {
+		t.Fatalf("imported %d blocks again, record %+v", stored, r)
+	}
+
+	// the file is imported again with other options
+	opts := smallChunks
+	opts.CidVersion = 1
+	opts.RawLeaves = true
+	v1, stored := importDataset(opts)
+	if stored == 0 || v1.CID == v0.CID || v1.Opts == v0.Opts {
+		t.Fatalf("imported %d blocks with other options, record %+v", stored, v1)
+	}
+	c, err := cid.Decode(v1.CID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Version() != 1 {
+		t.Errorf("recorded cid %s, expected a v1 cid", c)
+	}
+	if r, stored := importDataset(opts); stored != 0 || r.CID != v1.CID {
+		t.Fatalf("imported %d blocks again, record %+v", stored, r)
+	}
+}
+
+func TestReadDatasetRecordsWithoutOpts(t *testing.T) {
+	recordPath := path.Join(t.TempDir(), datasetRecordJSON)
+	if err := os.WriteFile(recordPath, []byte(`{"/data":{"path":"/data","name":"data","size":1,"cid":"QmTest"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	records, err := readDatasetRecords(recordPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := datasetOptsKey(api.ImportOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := records["/data"]; r == nil || r.Opts != expected {
+		t.Fatalf("record written without options: %+v, expected the default options", r)
+	}
+}
@@ -0,0 +1,45 @@
+package impl
+
+import (
+	"context"
+
+	"github.com/filedrive-team/filejoy/api"
+	"github.com/filedrive-team/filejoy/node"
+	"golang.org/x/xerrors"
+)
+
+type FilestoreAPI struct {
+	Node *node.Node
+}
+
+func (a *FilestoreAPI) FilestoreVerify(ctx context.Context) (chan api.FilestoreRef, error) {
+	if a.Node.Filestore == nil {
+		return nil, xerrors.New("filestore is not enabled, set enable_filestore in config")
+	}
+	res, err := a.Node.Filestore.Verify(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan api.FilestoreRef)
+	go func() {
+		defer close(out)
+		for st := range res {
+			ref := api.FilestoreRef{
+				Cid:    st.Cid,
+				Path:   st.Ref.Path,
+				Offset: st.Ref.Offset,
+				Size:   st.Ref.Size,
+				Status: st.Status,
+			}
+			if st.Err != nil {
+				ref.Err = st.Err.Error()
+			}
+			select {
+			case out <- ref:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
@@ -8,6 +8,9 @@ type FullNodeAPI struct {
 	CommonAPI
 	NetAPI
 	DagAPI
+	FilestoreAPI
+	RepoAPI
+	PinAPI
 }
 
 var _ api.FullNode = &FullNodeAPI{}
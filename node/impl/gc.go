@@ -0,0 +1,257 @@
+package impl
+
+import (
+	"context"
+	"sync"
+
+	"github.com/filedrive-team/filejoy/api"
+	"github.com/filedrive-team/filejoy/node"
+	"github.com/filedrive-team/filejoy/node/pinner"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	format "github.com/ipfs/go-ipld-format"
+	"golang.org/x/xerrors"
+)
+
+// ErrNoPins is returned by RepoGC when nothing is pinned, every block would
+// be removed
+var ErrNoPins = xerrors.New("nothing is pinned, gc would remove every block: pin the dags to keep or force it")
+
+// gcMarkConcurrency is the number of blocks read at a time while marking
+const gcMarkConcurrency = 16
+
+// mhSet is a set of blocks by multihash, the blockstore keys do not keep
+// the cid version and codec
+type mhSet map[string]struct{}
+
+func (s mhSet) add(c cid.Cid) {
+	s[string(c.Hash())] = struct{}{}
+}
+
+func (s mhSet) has(c cid.Cid) bool {
+	_, ok := s[string(c.Hash())]
+	return ok
+}
+
+// markPinned returns the blocks kept by the pins other than the pin of
+// except, if defined, and by the checkpoints of interrupted imports. Blocks
+// missing from the blockstore are skipped, any other error fails the mark
+// so that nothing is removed by mistake
+func markPinned(ctx context.Context, n *node.Node, except cid.Cid) (mhSet, error) {
+	pins, err := n.Pinner.List()
+	if err != nil {
+		return nil, xerrors.Errorf("list pins: %w", err)
+	}
+	roots, err := checkpointRoots(n.Datastore)
+	if err != nil {
+		return nil, xerrors.Errorf("list import checkpoints: %w", err)
+	}
+	marked := mhSet{}
+	for _, p := range pins {
+		if except.Defined() && p.Cid.Equals(except) {
+			continue
+		}
+		if p.Mode == pinner.Recursive {
+			roots = append(roots, p.Cid)
+		} else {
+			marked.add(p.Cid)
+		}
+	}
+	var mu sync.Mutex
+	err = walkDag(ctx, &offlineng{ng: n.Blockstore}, roots, gcMarkConcurrency, 0, func(c cid.Cid, _ int, _ format.Node, err error) error {
+		if err != nil {
+			if !isMissing(err) {
+				return xerrors.Errorf("load %s: %w", c, err)
+			}
+			log.Warnf("pinned block %s is missing", c)
+		}
+		mu.Lock()
+		marked.add(c)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return marked, nil
+}
+
+// RepoGC removes the blocks which are neither pinned nor reachable from a
+// recursive pin, every removed block is streamed and the last ref reports
+// the totals. Imports wait until it is done. Unless forced, it refuses to
+// run if nothing is pinned, as every block would be removed
+func (a *RepoAPI) RepoGC(ctx context.Context, opts api.RepoGCOpts) (chan api.GCRef, error) {
+	out := make(chan api.GCRef)
+	go func() {
+		defer close(out)
+		send := func(ref api.GCRef) bool {
+			select {
+			case out <- ref:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		if res := collectGarbage(ctx, a.Node, opts, send); res.Done {
+			send(res)
+		}
+	}()
+	return out, nil
+}
+
+// AutoGC returns the gc run by the quota of n once the blockstore grows over
+// the watermark
+func AutoGC(ctx context.Context, n *node.Node) func() {
+	return func() {
+		res := collectGarbage(ctx, n, api.RepoGCOpts{}, func(api.GCRef) bool { return true })
+		if res.Err != "" {
+			log.Errorf("gc: %s", res.Err)
+		}
+		log.Infof("gc removed %d blocks, %d bytes", res.Removed, res.Freed)
+	}
+}
+
+// collectGarbage removes the blocks not kept by a pin, removed is called
+// for every unpinned block, with Err set if it could not be removed, and
+// stops the sweep if it returns false. The totals are returned, with Done
+// set unless the sweep was stopped
+func collectGarbage(ctx context.Context, n *node.Node, opts api.RepoGCOpts, removed func(api.GCRef) bool) api.GCRef {
+	res := api.GCRef{Done: true}
+	defer n.GCLocker.GCLock().Unlock()
+
+	if !opts.DryRun && !opts.Force {
+		empty, err := n.Pinner.Empty()
+		if err != nil {
+			res.Err = xerrors.Errorf("list pins: %w", err).Error()
+			return res
+		}
+		if empty {
+			res.Err = ErrNoPins.Error()
+			return res
+		}
+	}
+	marked, err := markPinned(ctx, n, cid.Undef)
+	if err != nil {
+		res.Err = xerrors.Errorf("mark: %w", err).Error()
+		return res
+	}
+	keys, err := n.Blockstore.AllKeysChan(ctx)
+	if err != nil {
+		res.Err = xerrors.Errorf("sweep: %w", err).Error()
+		return res
+	}
+	for c := range keys {
+		if marked.has(c) {
+			continue
+		}
+		ref := api.GCRef{Cid: c}
+		size, err := n.Blockstore.GetSize(c)
+		if err == nil && !opts.DryRun {
+			err = n.Blockstore.DeleteBlock(c)
+		}
+		if err != nil {
+			ref.Err = err.Error()
+		} else {
+			ref.Size = uint64(size)
+			res.Removed++
+			res.Freed += uint64(size)
+		}
+		if !removed(ref) {
+			res.Done = false
+			return res
+		}
+	}
+	if ctx.Err() != nil {
+		res.Done = false
+		return res
+	}
+	if !opts.DryRun {
+		// badger only reclaims the space of deleted values on value log gc
+		if gcds, ok := n.Storage.(datastore.GCDatastore); ok {
+			if err := gcds.CollectGarbage(); err != nil {
+				res.Err = xerrors.Errorf("value log gc: %w", err).Error()
+			}
+		}
+	}
+	return res
+}
+
+// DagRm removes the blocks of the dag of c from the blockstore and unpins
+// it, the blocks kept by other pins are left. Every removed block is
+// streamed and the last ref reports the totals
+func (a *DagAPI) DagRm(ctx context.Context, c cid.Cid, opts api.RepoGCOpts) (chan api.GCRef, error) {
+	out := make(chan api.GCRef)
+	go func() {
+		defer close(out)
+		send := func(ref api.GCRef) error {
+			select {
+			case out <- ref:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		fail := func(err error) {
+			send(api.GCRef{
+				Done: true,
+				Err:  err.Error(),
+			})
+		}
+		defer a.Node.GCLocker.GCLock().Unlock()
+
+		marked, err := markPinned(ctx, a.Node, c)
+		if err != nil {
+			fail(xerrors.Errorf("mark: %w", err))
+			return
+		}
+		if !opts.DryRun {
+			// unpin first, gc removes what is left if the removal fails
+			if err := a.Node.Pinner.Unpin(c); err != nil && err != pinner.ErrNotPinned {
+				fail(xerrors.Errorf("unpin: %w", err))
+				return
+			}
+		}
+		var mu sync.Mutex
+		res := api.GCRef{Done: true}
+		err = walkDag(ctx, a.nodeGetter(false), []cid.Cid{c}, gcMarkConcurrency, 0, func(c cid.Cid, _ int, nd format.Node, err error) error {
+			if err != nil {
+				if isMissing(err) {
+					return nil
+				}
+				return xerrors.Errorf("load %s: %w", c, err)
+			}
+			if marked.has(c) {
+				mu.Lock()
+				res.Kept++
+				mu.Unlock()
+				return nil
+			}
+			// the links of nd are already decoded, the walk goes on
+			ref := api.GCRef{
+				Cid:  c,
+				Size: uint64(len(nd.RawData())),
+			}
+			if !opts.DryRun {
+				if err := a.Node.Blockstore.DeleteBlock(c); err != nil {
+					ref.Size = 0
+					ref.Err = err.Error()
+				}
+			}
+			if ref.Err == "" {
+				mu.Lock()
+				res.Removed++
+				res.Freed += ref.Size
+				mu.Unlock()
+			}
+			return send(ref)
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				fail(err)
+			}
+			return
+		}
+		send(res)
+	}()
+	return out, nil
+}
@@ -0,0 +1,307 @@
+package impl
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/filedrive-team/filejoy/api"
+	"github.com/filedrive-team/filejoy/node"
+	"github.com/filedrive-team/filejoy/node/pinner"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+)
+
+// runGC runs RepoGC and returns the removed blocks and the totals
+func runGC(t *testing.T, n *node.Node, opts api.RepoGCOpts) ([]api.GCRef, api.GCRef) {
+	t.Helper()
+	refs, err := (&RepoAPI{Node: n}).RepoGC(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var removed []api.GCRef
+	var done api.GCRef
+	for ref := range refs {
+		if ref.Done {
+			done = ref
+			continue
+		}
+		if ref.Err != "" {
+			t.Errorf("remove %s: %s", ref.Cid, ref.Err)
+		}
+		removed = append(removed, ref)
+	}
+	if !done.Done {
+		t.Fatal("gc did not complete")
+	}
+	return removed, done
+}
+
+func TestRepoGC(t *testing.T) {
+	n := newTestNode(t)
+	recursive := addTestFile(t, n, randData(1, 10<<10), smallChunks)
+	direct := addTestFile(t, n, randData(2, 10<<10), smallChunks)
+	unpinned := addTestFile(t, n, randData(3, 10<<10), smallChunks)
+	interrupted := addTestFile(t, n, randData(4, 10<<10), smallChunks)
+	recursiveCids := dagCids(t, n, recursive)
+	directCids := dagCids(t, n, direct)
+	unpinnedCids := dagCids(t, n, unpinned)
+	interruptedCids := dagCids(t, n, interrupted)
+
+	for _, p := range []*pinner.Pin{
+		{Cid: recursive, Mode: pinner.Recursive},
+		{Cid: direct, Mode: pinner.Direct},
+	} {
+		if err := n.Pinner.Pin(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the leaves imported so far by an interrupted import are kept for the
+	// resume
+	cp, err := openCheckpoint(n.Datastore, "/interrupted", fakeFileInfo{}, smallChunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var links []*IdxLink
+	for i, c := range interruptedCids[1:4] {
+		links = append(links, checkpointIdxLink(i, c))
+	}
+	if err := cp.save(links, 3<<10); err != nil {
+		t.Fatal(err)
+	}
+
+	// a dry run removes nothing
+	wouldRemove, done := runGC(t, n, api.RepoGCOpts{DryRun: true})
+	expected := len(directCids) - 1 + len(unpinnedCids) + len(interruptedCids) - 3
+	if len(wouldRemove) != expected || done.Removed != int64(expected) {
+		t.Fatalf("dry run would remove %d blocks, totals %d, expected %d", len(wouldRemove), done.Removed, expected)
+	}
+	if has := countHas(t, n, unpinnedCids); has != len(unpinnedCids) {
+		t.Fatalf("dry run removed %d blocks", len(unpinnedCids)-has)
+	}
+
+	removed, done := runGC(t, n, api.RepoGCOpts{})
+	if len(removed) != expected || done.Removed != int64(expected) {
+		t.Fatalf("removed %d blocks, totals %d, expected %d", len(removed), done.Removed, expected)
+	}
+	var freed uint64
+	for _, ref := range removed {
+		freed += ref.Size
+	}
+	if freed != done.Freed {
+		t.Errorf("freed %d bytes, totals %d", freed, done.Freed)
+	}
+	if has := countHas(t, n, recursiveCids); has != len(recursiveCids) {
+		t.Errorf("recursive pin kept %d of %d blocks", has, len(recursiveCids))
+	}
+	if has := countHas(t, n, directCids[:1]); has != 1 {
+		t.Error("direct pin root removed")
+	}
+	if has := countHas(t, n, directCids[1:]); has != 0 {
+		t.Errorf("direct pin kept %d blocks below the root", has)
+	}
+	if has := countHas(t, n, unpinnedCids); has != 0 {
+		t.Errorf("%d unpinned blocks kept", has)
+	}
+	if has := countHas(t, n, interruptedCids[1:4]); has != 3 {
+		t.Errorf("checkpoint kept %d of 3 blocks", has)
+	}
+
+	// nothing is left to collect
+	if removed, _ := runGC(t, n, api.RepoGCOpts{}); len(removed) != 0 {
+		t.Errorf("second gc removed %d blocks", len(removed))
+	}
+}
+
+func TestRepoGCEmptyPinset(t *testing.T) {
+	n := newTestNode(t)
+	cids := dagCids(t, n, addTestFile(t, n, randData(1, 10<<10), smallChunks))
+
+	removed, done := runGC(t, n, api.RepoGCOpts{})
+	if done.Err != ErrNoPins.Error() {
+		t.Fatalf("gc with no pin: %q, expected %q", done.Err, ErrNoPins)
+	}
+	if len(removed) != 0 || countHas(t, n, cids) != len(cids) {
+		t.Fatal("gc with no pin removed blocks")
+	}
+	// a dry run shows what would be removed
+	if wouldRemove, _ := runGC(t, n, api.RepoGCOpts{DryRun: true}); len(wouldRemove) != len(cids) {
+		t.Errorf("dry run would remove %d of %d blocks", len(wouldRemove), len(cids))
+	}
+	if removed, _ := runGC(t, n, api.RepoGCOpts{Force: true}); len(removed) != len(cids) {
+		t.Errorf("forced gc removed %d of %d blocks", len(removed), len(cids))
+	}
+}
+
+func TestRepoGCWaitsForImports(t *testing.T) {
+	n := newTestNode(t)
+	if err := n.Pinner.Pin(&pinner.Pin{Cid: addTestFile(t, n, randData(1, 1<<10), smallChunks), Mode: pinner.Recursive}); err != nil {
+		t.Fatal(err)
+	}
+	// an import holds the pin lock until its root is pinned
+	unlocker := n.GCLocker.PinLock()
+	c := addTestFile(t, n, randData(2, 10<<10), smallChunks)
+
+	refs, err := (&RepoAPI{Node: n}).RepoGC(context.Background(), api.RepoGCOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ref := <-refs:
+		t.Fatalf("gc ran during an import: %+v", ref)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := n.Pinner.Pin(&pinner.Pin{Cid: c, Mode: pinner.Recursive}); err != nil {
+		t.Fatal(err)
+	}
+	unlocker.Unlock()
+
+	var done api.GCRef
+	for ref := range refs {
+		if !ref.Done {
+			t.Errorf("gc removed %s of the import", ref.Cid)
+		}
+		done = ref
+	}
+	if !done.Done || done.Removed != 0 {
+		t.Fatalf("gc after the import: %+v", done)
+	}
+}
+
+func TestCancelledImportReleasesPinLock(t *testing.T) {
+	n := newTestNode(t)
+	a := &CommonAPI{Node: n}
+	// a client gone in the middle of an import must not keep gc waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	out, err := a.AddReader(ctx, &slowReader{data: randData(1, 64<<10)}, api.ImportOpts{Pin: &api.PinOpts{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	locked := make(chan struct{})
+	go func() {
+		n.GCLocker.GCLock().Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the import kept the pin lock after the client was gone")
+	}
+	for range out {
+	}
+}
+
+// slowReader returns data a little at a time, so that imports last
+type slowReader struct {
+	data []byte
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(time.Millisecond)
+	if len(p) > 1024 {
+		p = p[:1024]
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// checkpointIdxLink returns the checkpoint link of the leaf c at idx
+func checkpointIdxLink(idx int, c cid.Cid) *IdxLink {
+	return &IdxLink{
+		Idx:  idx,
+		Link: &format.Link{Cid: c},
+	}
+}
+
+// fakeFileInfo is the os.FileInfo of a file which is not on disk
+type fakeFileInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (fi fakeFileInfo) Size() int64        { return fi.size }
+func (fi fakeFileInfo) ModTime() time.Time { return time.Time{} }
+
+func TestDagRmKeepsSharedBlocks(t *testing.T) {
+	n := newTestNode(t)
+	// the files start with the same chunks
+	shared := randData(1, 4<<10)
+	x := addTestFile(t, n, append(append([]byte{}, shared...), randData(2, 6<<10)...), smallChunks)
+	y := addTestFile(t, n, append(append([]byte{}, shared...), randData(3, 6<<10)...), smallChunks)
+	for _, c := range []cid.Cid{x, y} {
+		if err := n.Pinner.Pin(&pinner.Pin{Cid: c, Mode: pinner.Recursive}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	xCids := dagCids(t, n, x)
+	yCids := dagCids(t, n, y)
+	ySet := cid.NewSet()
+	for _, c := range yCids {
+		ySet.Add(c)
+	}
+	var xOnly []cid.Cid
+	for _, c := range xCids {
+		if !ySet.Has(c) {
+			xOnly = append(xOnly, c)
+		}
+	}
+	if kept := len(xCids) - len(xOnly); kept != 4 {
+		t.Fatalf("the files share %d blocks, expected 4", kept)
+	}
+
+	rm := func(opts api.RepoGCOpts) ([]api.GCRef, api.GCRef) {
+		refs, err := (&DagAPI{Node: n}).DagRm(context.Background(), x, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var removed []api.GCRef
+		var done api.GCRef
+		for ref := range refs {
+			if ref.Done {
+				done = ref
+				continue
+			}
+			if ref.Err != "" {
+				t.Errorf("remove %s: %s", ref.Cid, ref.Err)
+			}
+			removed = append(removed, ref)
+		}
+		if done.Err != "" || !done.Done {
+			t.Fatalf("dag rm: %+v", done)
+		}
+		return removed, done
+	}
+
+	wouldRemove, done := rm(api.RepoGCOpts{DryRun: true})
+	if len(wouldRemove) != len(xOnly) || done.Kept != 4 {
+		t.Fatalf("dry run would remove %d blocks and keep %d, expected %d and 4", len(wouldRemove), done.Kept, len(xOnly))
+	}
+	if has := countHas(t, n, xCids); has != len(xCids) {
+		t.Fatalf("dry run removed %d blocks", len(xCids)-has)
+	}
+	if _, err := n.Pinner.Get(x); err != nil {
+		t.Fatalf("dry run unpinned: %v", err)
+	}
+
+	removed, done := rm(api.RepoGCOpts{})
+	if len(removed) != len(xOnly) || done.Removed != int64(len(xOnly)) || done.Kept != 4 {
+		t.Fatalf("removed %d blocks, totals %+v, expected %d removed and 4 kept", len(removed), done, len(xOnly))
+	}
+	if has := countHas(t, n, xOnly); has != 0 {
+		t.Errorf("%d blocks only in the removed dag kept", has)
+	}
+	if has := countHas(t, n, yCids); has != len(yCids) {
+		t.Errorf("the other pin kept %d of %d blocks", has, len(yCids))
+	}
+	if _, err := n.Pinner.Get(x); err != pinner.ErrNotPinned {
+		t.Errorf("removed dag still pinned: %v", err)
+	}
+}
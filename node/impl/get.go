@@ -0,0 +1,423 @@
+package impl
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/filedrive-team/filejoy/api"
+	"github.com/filedrive-team/filejoy/node/dagreader"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+	ufsio "github.com/ipfs/go-unixfs/io"
+	pb "github.com/ipfs/go-unixfs/pb"
+	"github.com/multiformats/go-multihash"
+	"golang.org/x/xerrors"
+)
+
+// kinds of unixfs nodes get knows how to write out
+const (
+	kindFile = iota
+	kindDir
+	kindSymlink
+)
+
+// unixfsKind tells whether nd is a file, a directory, which may be HAMT
+// sharded, or a symlink
+func unixfsKind(nd format.Node) (int, *unixfs.FSNode, error) {
+	switch n := nd.(type) {
+	case *merkledag.RawNode:
+		return kindFile, nil, nil
+	case *merkledag.ProtoNode:
+		fsn, err := unixfs.FSNodeFromBytes(n.Data())
+		if err != nil {
+			return 0, nil, err
+		}
+		switch fsn.Type() {
+		case pb.Data_File, pb.Data_Raw:
+			return kindFile, fsn, nil
+		case pb.Data_Directory, pb.Data_HAMTShard:
+			return kindDir, fsn, nil
+		case pb.Data_Symlink:
+			return kindSymlink, fsn, nil
+		default:
+			return 0, nil, xerrors.Errorf("unsupported unixfs type %s", fsn.Type())
+		}
+	default:
+		return 0, nil, xerrors.Errorf("unsupported node %T", nd)
+	}
+}
+
+// forEachEntry calls fn with the name and node of every entry of the
+// directory nd, entry names which would leave the directory and duplicate
+// names are rejected
+func forEachEntry(ctx context.Context, dagServ format.DAGService, nd format.Node, fn func(string, format.Node) error) error {
+	dir, err := ufsio.NewDirectoryFromNode(dagServ, nd)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]struct{})
+	return dir.ForEachLink(ctx, func(l *format.Link) error {
+		if l.Name == "" || l.Name == "." || l.Name == ".." || strings.Contains(l.Name, "/") {
+			return xerrors.Errorf("invalid entry name %q in %s", l.Name, nd.Cid())
+		}
+		if _, ok := seen[l.Name]; ok {
+			return xerrors.Errorf("duplicate entry name %q in %s", l.Name, nd.Cid())
+		}
+		seen[l.Name] = struct{}{}
+		child, err := dagServ.Get(ctx, l.Cid)
+		if err != nil {
+			return err
+		}
+		return fn(l.Name, child)
+	})
+}
+
+// treeSize returns the total size of the files under nd
+func treeSize(ctx context.Context, dagServ format.DAGService, nd format.Node) (int64, error) {
+	kind, fsn, err := unixfsKind(nd)
+	if err != nil {
+		return 0, err
+	}
+	switch kind {
+	case kindFile:
+		return int64(fileSize(nd, fsn)), nil
+	case kindDir:
+		var size int64
+		err := forEachEntry(ctx, dagServ, nd, func(_ string, child format.Node) error {
+			s, err := treeSize(ctx, dagServ, child)
+			size += s
+			return err
+		})
+		return size, err
+	}
+	return 0, nil
+}
+
+// treeWriter writes unixfs dags out as local files or tar streams, written
+// file data is counted in pb if set
+type treeWriter struct {
+	dagServ format.DAGService
+	// ds has the recorded import options files are verified with
+	ds datastore.Datastore
+	// window is the number of blocks fetched ahead by file readers
+	window int
+	pb     *pbar
+}
+
+// writeTree recreates the unixfs dag nd at p, the recorded modes and mtimes
+// are restored
+func (tw *treeWriter) writeTree(ctx context.Context, nd format.Node, p string, opts api.GetOpts) error {
+	kind, fsn, err := unixfsKind(nd)
+	if err != nil {
+		return err
+	}
+	switch kind {
+	case kindFile:
+		if err := tw.writeFile(ctx, nd, p, opts.Resume); err != nil {
+			return err
+		}
+		if opts.Verify {
+			if err := verifyFile(ctx, tw.ds, tw.dagServ, nd, p); err != nil {
+				return err
+			}
+		}
+	case kindDir:
+		if err := os.MkdirAll(p, 0755); err != nil {
+			return err
+		}
+		if err := forEachEntry(ctx, tw.dagServ, nd, func(name string, child format.Node) error {
+			cp := filepath.Join(p, name)
+			if err := checkNoSymlink(cp, child); err != nil {
+				return err
+			}
+			return tw.writeTree(ctx, child, cp, opts)
+		}); err != nil {
+			return err
+		}
+	case kindSymlink:
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		// links have no mode of their own
+		return os.Symlink(string(fsn.Data()), p)
+	}
+	meta, err := NodeMeta(nd)
+	if err != nil {
+		return err
+	}
+	return restoreMeta(p, meta)
+}
+
+// checkNoSymlink refuses to write the entry nd to p through a symlink found
+// there, left by an earlier get or by an entry whose name only differs in
+// case, which would lead out of the output directory. Symlink entries
+// replace the link itself
+func checkNoSymlink(p string, nd format.Node) error {
+	finfo, err := os.Lstat(p)
+	if err != nil || finfo.Mode()&os.ModeSymlink == 0 {
+		return nil
+	}
+	if kind, _, err := unixfsKind(nd); err == nil && kind == kindSymlink {
+		return nil
+	}
+	return xerrors.Errorf("%s is a symlink, refusing to write through it", p)
+}
+
+// writeFile writes the unixfs file nd to p. With resume, an existing file
+// no longer than nd is taken as the written part, and the rest is appended
+func (tw *treeWriter) writeFile(ctx context.Context, nd format.Node, p string, resume bool) error {
+	fdr, err := dagreader.New(ctx, nd, tw.dagServ, tw.window)
+	if err != nil {
+		return err
+	}
+	defer fdr.Close()
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	var offset int64
+	if resume {
+		if finfo, err := os.Stat(p); err == nil && finfo.Mode().IsRegular() && uint64(finfo.Size()) <= fdr.Size() {
+			flag = os.O_WRONLY
+			offset = finfo.Size()
+		}
+	}
+	f, err := os.OpenFile(p, flag, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if offset > 0 {
+		if _, err := fdr.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		tw.pb.add(offset)
+	}
+	if _, err := io.Copy(io.MultiWriter(f, tw.pb), fdr); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// importOptsPrefix is the datastore namespace of the options the files
+// imported by the node were imported with, by root cid
+var importOptsPrefix = datastore.NewKey("/import-opts")
+
+// saveImportOpts records the options the file c was imported with, only the
+// ones shaping the dag are kept
+func saveImportOpts(ds datastore.Datastore, c cid.Cid, opts api.ImportOpts) error {
+	v, err := json.Marshal(api.ImportOpts{
+		CidVersion: opts.CidVersion,
+		HashFunc:   opts.HashFunc,
+		RawLeaves:  opts.RawLeaves,
+		Chunker:    opts.Chunker,
+		Layout:     opts.Layout,
+		MaxLinks:   opts.MaxLinks,
+	})
+	if err != nil {
+		return err
+	}
+	return ds.Put(importOptsPrefix.ChildString(c.String()), v)
+}
+
+// loadImportOpts returns the recorded import options of the file c, false
+// if there are none
+func loadImportOpts(ds datastore.Datastore, c cid.Cid) (api.ImportOpts, bool, error) {
+	var opts api.ImportOpts
+	v, err := ds.Get(importOptsPrefix.ChildString(c.String()))
+	if err == datastore.ErrNotFound {
+		return opts, false, nil
+	}
+	if err != nil {
+		return opts, false, err
+	}
+	if err := json.Unmarshal(v, &opts); err != nil {
+		return opts, false, err
+	}
+	return opts, true, nil
+}
+
+// verifyFile re-imports the file at p with the import options of nd and
+// checks it gets the cid of nd. The options recorded at import are used,
+// files imported elsewhere are rebuilt with the options told from their
+// dag, which only works for fixed size chunkers, so a different cid then
+// means the file cannot be verified rather than it is corrupted
+func verifyFile(ctx context.Context, ds datastore.Datastore, dagServ format.DAGService, nd format.Node, p string) error {
+	opts, recorded, err := loadImportOpts(ds, nd.Cid())
+	if err != nil {
+		return err
+	}
+	if !recorded {
+		opts, err = inferImportOpts(ctx, dagServ, nd)
+		if err != nil {
+			return xerrors.Errorf("cannot verify %s: %w", p, err)
+		}
+	}
+	meta, err := NodeMeta(nd)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	vnd, err := BuildFileNode(ctx, f, NewDiscardDAG(), opts, meta)
+	if err != nil {
+		return xerrors.Errorf("verify %s: %w", p, err)
+	}
+	if vnd.Cid().Equals(nd.Cid()) {
+		return nil
+	}
+	if !recorded {
+		return xerrors.Errorf("cannot verify %s: the import options of %s are unknown, rebuilt with the options told from its dag it gets %s", p, nd.Cid(), vnd.Cid())
+	}
+	return xerrors.Errorf("verify %s: got %s, expected %s; the file is corrupted", p, vnd.Cid(), nd.Cid())
+}
+
+// inferImportOpts works out the options the file nd was imported with from
+// its dag. Only fixed size chunkers can be told from the leaves
+func inferImportOpts(ctx context.Context, dagServ format.DAGService, nd format.Node) (api.ImportOpts, error) {
+	prefix := nd.Cid().Prefix()
+	opts := api.ImportOpts{
+		CidVersion: int(prefix.Version),
+		HashFunc:   multihash.Codes[prefix.MhType],
+	}
+	links := nd.Links()
+	// walk down the first links to the first leaf
+	leaf := nd
+	var first format.Node
+	for len(leaf.Links()) > 0 {
+		child, err := dagServ.Get(ctx, leaf.Links()[0].Cid)
+		if err != nil {
+			return opts, err
+		}
+		if first == nil {
+			first = child
+		}
+		leaf = child
+	}
+	_, fsn, err := unixfsKind(leaf)
+	if err != nil {
+		return opts, err
+	}
+	opts.RawLeaves = fsn == nil
+	trickle := fsn != nil && fsn.Type() == pb.Data_Raw
+	if size := fileSize(leaf, fsn); size > 0 {
+		opts.Chunker = fmt.Sprintf("size-%d", size)
+	}
+	if len(links) == 0 {
+		return opts, nil
+	}
+
+	// a trickle root has leaves first and then sub trees, a balanced root
+	// has either. Both build the same root over a single leaf, except for
+	// an empty one, which only a balanced root wraps for its metadata
+	if !trickle && len(first.Links()) == 0 {
+		last, err := dagServ.Get(ctx, links[len(links)-1].Cid)
+		if err != nil {
+			return opts, err
+		}
+		trickle = (len(links) == 1 && fileSize(leaf, fsn) > 0) || len(last.Links()) > 0
+	}
+	if trickle {
+		opts.Layout = LayoutTrickle
+		// full leaves all have the same link size, the last leaf may be
+		// shorter and sub trees are larger
+		leaves := 0
+		for leaves < len(links) && links[leaves].Size <= links[0].Size {
+			leaves++
+		}
+		if leaves < len(links) || leaves > maxLinks(opts) {
+			opts.MaxLinks = leaves
+		}
+		return opts, nil
+	}
+	if n := len(first.Links()); n > 0 {
+		opts.MaxLinks = n
+	} else if len(links) > maxLinks(opts) {
+		opts.MaxLinks = len(links)
+	}
+	return opts, nil
+}
+
+// fileSize returns the size of the file data of nd, fsn is nil for raw
+// nodes
+func fileSize(nd format.Node, fsn *unixfs.FSNode) uint64 {
+	if fsn == nil {
+		return uint64(len(nd.RawData()))
+	}
+	return fsn.FileSize()
+}
+
+// writeTar writes the unixfs dag nd as a tar stream entry named name,
+// directories are followed by their entries
+func (tw *treeWriter) writeTar(ctx context.Context, nd format.Node, name string, w *tar.Writer) error {
+	kind, fsn, err := unixfsKind(nd)
+	if err != nil {
+		return err
+	}
+	meta, err := NodeMeta(nd)
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    int64(meta.Mode),
+		ModTime: meta.ModTime,
+		Format:  tar.FormatPAX,
+	}
+	if meta.ModTime.IsZero() {
+		hdr.ModTime = time.Unix(0, 0)
+	}
+	switch kind {
+	case kindFile:
+		fdr, err := dagreader.New(ctx, nd, tw.dagServ, tw.window)
+		if err != nil {
+			return err
+		}
+		defer fdr.Close()
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = int64(fdr.Size())
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+		}
+		if err := w.WriteHeader(hdr); err != nil {
+			return err
+		}
+		var dst io.Writer = w
+		if tw.pb != nil {
+			dst = io.MultiWriter(w, tw.pb)
+		}
+		_, err = io.Copy(dst, fdr)
+		return err
+	case kindDir:
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+		if hdr.Mode == 0 {
+			hdr.Mode = 0755
+		}
+		if err := w.WriteHeader(hdr); err != nil {
+			return err
+		}
+		return forEachEntry(ctx, tw.dagServ, nd, func(entry string, child format.Node) error {
+			return tw.writeTar(ctx, child, path.Join(name, entry), w)
+		})
+	default:
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = string(fsn.Data())
+		hdr.Mode = 0777
+		return w.WriteHeader(hdr)
+	}
+}
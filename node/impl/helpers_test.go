@@ -0,0 +1,89 @@
+package impl
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/filedrive-team/filejoy/api"
+	"github.com/filedrive-team/filejoy/node"
+	ncfg "github.com/filedrive-team/filejoy/node/config"
+	"github.com/filedrive-team/filejoy/node/pinner"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+)
+
+// newTestNode returns an offline node keeping everything in memory
+func newTestNode(t *testing.T) *node.Node {
+	t.Helper()
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	bs := blockstore.NewBlockstore(ds)
+	return &node.Node{
+		Datastore:  ds,
+		Blockstore: bs,
+		Dagserv:    merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs))),
+		Pinner:     pinner.New(ds),
+		GCLocker:   blockstore.NewGCLocker(),
+		Config:     &ncfg.Config{},
+	}
+}
+
+// randData returns size bytes of data generated from seed
+func randData(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+// smallChunks splits files in 1KiB chunks, so that small files have many
+// blocks
+var smallChunks = api.ImportOpts{
+	Chunker: "size-1024",
+}
+
+// addTestFile imports data into n and returns the root of the file dag
+func addTestFile(t *testing.T, n *node.Node, data []byte, opts api.ImportOpts) cid.Cid {
+	t.Helper()
+	nd, err := BuildFileNode(context.Background(), bytes.NewReader(data), n.Dagserv, opts, FileMeta{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nd.Cid()
+}
+
+// dagCids returns every block of the dag of root
+func dagCids(t *testing.T, n *node.Node, root cid.Cid) []cid.Cid {
+	t.Helper()
+	var cids []cid.Cid
+	err := merkledag.Walk(context.Background(), func(ctx context.Context, c cid.Cid) ([]*format.Link, error) {
+		cids = append(cids, c)
+		return merkledag.GetLinksDirect(n.Dagserv)(ctx, c)
+	}, root, cid.NewSet().Visit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cids
+}
+
+// countHas returns the number of cids stored in the blockstore of n
+func countHas(t *testing.T, n *node.Node, cids []cid.Cid) int {
+	t.Helper()
+	has := 0
+	for _, c := range cids {
+		ok, err := n.Blockstore.Has(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			has++
+		}
+	}
+	return has
+}
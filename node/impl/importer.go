@@ -2,23 +2,44 @@ package impl
 
 import (
 	"context"
-	"fmt"
 	"io"
 	"math"
+	"strconv"
+	"strings"
 	"sync"
 
+	"github.com/filedrive-team/filejoy/api"
 	"github.com/ipfs/go-cid"
+	chunker "github.com/ipfs/go-ipfs-chunker"
+	files "github.com/ipfs/go-ipfs-files"
+	posinfo "github.com/ipfs/go-ipfs-posinfo"
 	format "github.com/ipfs/go-ipld-format"
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/ipfs/go-merkledag"
 	"github.com/ipfs/go-unixfs"
+	"github.com/ipfs/go-unixfs/importer/balanced"
+	ihelper "github.com/ipfs/go-unixfs/importer/helpers"
+	"github.com/ipfs/go-unixfs/importer/trickle"
+	"github.com/ipfs/go-verifcid"
+	mh "github.com/multiformats/go-multihash"
 	"golang.org/x/xerrors"
 
 	pb "github.com/ipfs/go-unixfs/pb"
 )
 
+const UnixfsLinksPerLevel = 1 << 10
 const UnixfsChunkSize uint64 = 1 << 20
 
+// dag layouts of imported files
+const (
+	LayoutBalanced = "balanced"
+	LayoutTrickle  = "trickle"
+)
+
+// trickleDepthRepeat is how many sub trees of the same depth a trickle node
+// holds, same as go-unixfs
+const trickleDepthRepeat = 4
+
 var log = logging.Logger("filejoy-node-impl")
 
 type linkAndSize struct {
@@ -74,6 +95,10 @@ func (n *FSNodeOverDag) AddChild(link *format.Link, fileSize uint64) error {
 	return nil
 }
 
+func (n *FSNodeOverDag) NumChildren() int {
+	return n.file.NumChildren()
+}
+
 func (n *FSNodeOverDag) SetFileData(fileData []byte) {
 	n.file.SetData(fileData)
 }
@@ -88,16 +113,219 @@ func (n *FSNodeOverDag) Commit() (format.Node, error) {
 	return n.dag, nil
 }
 
-func buildCidByLinks(ctx context.Context, links []*linkAndSize, dagServ format.DAGService) (cid.Cid, error) {
-	maxLinkNum := 1024
-	var linkList = make([]*linkAndSize, 0)
-	var needAdd = make([]format.Node, 0)
+// NewCidBuilder returns a cid builder for the cid version and the multihash
+// function name, an empty name means sha2-256
+func NewCidBuilder(version int, hashFunc string) (cid.Builder, error) {
+	prefix, err := merkledag.PrefixForCidVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	if hashFunc == "" {
+		return prefix, nil
+	}
+	mhType, ok := mh.Names[strings.ToLower(hashFunc)]
+	if !ok {
+		return nil, xerrors.Errorf("unrecognized hash function: %s", hashFunc)
+	}
+	if !verifcid.IsGoodHash(mhType) {
+		return nil, xerrors.Errorf("hash function %s is not supported by the blockservice", hashFunc)
+	}
+	if version == 0 && mhType != mh.SHA2_256 {
+		return nil, xerrors.Errorf("cid version 0 only supports sha2-256, got %s", hashFunc)
+	}
+	prefix.MhType = mhType
+	prefix.MhLength = -1
+	return prefix, nil
+}
+
+// CheckImportOpts validates the import options before any data is read
+func CheckImportOpts(opts api.ImportOpts) error {
+	if _, err := NewCidBuilder(opts.CidVersion, opts.HashFunc); err != nil {
+		return err
+	}
+	if _, err := NewSplitter(strings.NewReader(""), opts.Chunker); err != nil {
+		return err
+	}
+	switch opts.Layout {
+	case "", LayoutBalanced, LayoutTrickle:
+	default:
+		return xerrors.Errorf("unrecognized layout: %s", opts.Layout)
+	}
+	if opts.MaxLinks < 0 {
+		return xerrors.Errorf("max links should not be negative")
+	}
+	// only raw leaves can be kept as references to the source file
+	if opts.NoCopy && !opts.RawLeaves {
+		return xerrors.Errorf("no-copy requires raw leaves")
+	}
+	return nil
+}
+
+// maxLinks returns the max number of links per node for the import options.
+// Balanced dags keep filejoy's 1024 links per level, trickle dags default to
+// go-ipfs's links per block
+func maxLinks(opts api.ImportOpts) int {
+	if opts.MaxLinks > 0 {
+		return opts.MaxLinks
+	}
+	if opts.Layout == LayoutTrickle {
+		return ihelper.DefaultLinksPerBlock
+	}
+	return UnixfsLinksPerLevel
+}
+
+// NewSplitter returns the splitter for the chunker spec, an empty spec means
+// fixed size chunks of UnixfsChunkSize
+func NewSplitter(r io.Reader, spec string) (chunker.Splitter, error) {
+	if spec == "" {
+		return chunker.NewSizeSplitter(r, int64(UnixfsChunkSize)), nil
+	}
+	return chunker.FromString(r, spec)
+}
+
+// BuildFileNode imports the data of r as a balanced or trickle unixfs file
+// dag, the same way as go-ipfs does. In no-copy mode r should be a
+// files.FileInfo. A non empty meta is recorded on the root node
+func BuildFileNode(ctx context.Context, r io.Reader, dagServ format.DAGService, opts api.ImportOpts, meta FileMeta) (format.Node, error) {
+	cidBuilder, err := NewCidBuilder(opts.CidVersion, opts.HashFunc)
+	if err != nil {
+		return nil, err
+	}
+	params := ihelper.DagBuilderParams{
+		Maxlinks:   maxLinks(opts),
+		RawLeaves:  opts.RawLeaves,
+		CidBuilder: cidBuilder,
+		// the layouts write without a context
+		Dagserv: &ctxDAG{DAGService: dagServ, ctx: ctx},
+		NoCopy:  opts.NoCopy,
+	}
+	spl, err := NewSplitter(r, opts.Chunker)
+	if err != nil {
+		return nil, err
+	}
+	db, err := params.New(spl)
+	if err != nil {
+		return nil, err
+	}
+	var nd format.Node
+	if opts.Layout == LayoutTrickle {
+		nd, err = trickle.Layout(db)
+	} else {
+		nd, err = balanced.Layout(db)
+	}
+	if err != nil || meta.IsEmpty() {
+		return nd, err
+	}
+	if nd, err = withMeta(nd, meta, cidBuilder); err != nil {
+		return nil, err
+	}
+	if err := dagServ.Add(ctx, nd); err != nil {
+		return nil, err
+	}
+	return nd, nil
+}
+
+func newLeafNode(data []byte, fsNodeType pb.Data_DataType, cidBuilder cid.Builder, rawLeaves bool) (format.Node, error) {
+	if rawLeaves {
+		return merkledag.NewRawNodeWPrefix(data, cidBuilder)
+	}
+	return NewDagWithData(data, fsNodeType, cidBuilder)
+}
 
-	// cidbuilder
-	cidBuilder, err := merkledag.PrefixForCidVersion(0)
+// filestoreNode attaches the position of a raw leaf in the source file, so
+// that the filestore keeps a reference instead of the data
+func filestoreNode(nd format.Node, offset uint64, fi files.FileInfo) format.Node {
+	if _, ok := nd.(*merkledag.RawNode); !ok {
+		return nd
+	}
+	return &posinfo.FilestoreNode{
+		Node: nd,
+		PosInfo: &posinfo.PosInfo{
+			Offset:   offset,
+			FullPath: fi.AbsPath(),
+			Stat:     fi.Stat(),
+		},
+	}
+}
+
+// buildTrickleByLinks builds a trickle dag over the data links in the same
+// shape as trickle.Layout of go-unixfs
+func buildTrickleByLinks(ctx context.Context, links []*linkAndSize, dagServ format.DAGService, cidBuilder cid.Builder, maxLinkNum int, meta FileMeta) (cid.Cid, error) {
+	tb := &trickleBuilder{
+		links:      links,
+		maxLinkNum: maxLinkNum,
+		cidBuilder: cidBuilder,
+	}
+	root, _, err := tb.fill(NewFSNodeOverDag(pb.Data_File, cidBuilder), -1)
 	if err != nil {
 		return cid.Undef, err
 	}
+	if root, err = withMeta(root, meta, cidBuilder); err != nil {
+		return cid.Undef, err
+	}
+	tb.needAdd = append(tb.needAdd, root)
+	if err := dagServ.AddMany(ctx, tb.needAdd); err != nil {
+		log.Error(err)
+		return cid.Undef, err
+	}
+	return root.Cid(), nil
+}
+
+type trickleBuilder struct {
+	links      []*linkAndSize
+	pos        int
+	maxLinkNum int
+	cidBuilder cid.Builder
+	needAdd    []format.Node
+}
+
+func (tb *trickleBuilder) done() bool {
+	return tb.pos >= len(tb.links)
+}
+
+// fill adds data links to node until it is full, then appends sub trees of
+// increasing depth, up to maxDepth or unlimited if maxDepth is -1
+func (tb *trickleBuilder) fill(node *FSNodeOverDag, maxDepth int) (format.Node, uint64, error) {
+	for node.NumChildren() < tb.maxLinkNum && !tb.done() {
+		link := tb.links[tb.pos]
+		if err := node.AddChild(link.Link, link.FileSize); err != nil {
+			return nil, 0, err
+		}
+		tb.pos++
+	}
+	for depth := 1; maxDepth == -1 || depth < maxDepth; depth++ {
+		if tb.done() {
+			break
+		}
+		for repeat := 0; repeat < trickleDepthRepeat && !tb.done(); repeat++ {
+			child, childFileSize, err := tb.fill(NewFSNodeOverDag(pb.Data_File, tb.cidBuilder), depth)
+			if err != nil {
+				return nil, 0, err
+			}
+			link, err := format.MakeLink(child)
+			if err != nil {
+				return nil, 0, err
+			}
+			if err := node.AddChild(link, childFileSize); err != nil {
+				return nil, 0, err
+			}
+			tb.needAdd = append(tb.needAdd, child)
+		}
+	}
+	nd, err := node.Commit()
+	if err != nil {
+		return nil, 0, err
+	}
+	return nd, node.file.FileSize(), nil
+}
+
+// buildCidByLinks builds a balanced dag over the data links, meta is
+// recorded on the root node. A single link is the root itself, and then
+// first is its node
+func buildCidByLinks(ctx context.Context, links []*linkAndSize, first format.Node, dagServ format.DAGService, cidBuilder cid.Builder, maxLinkNum int, meta FileMeta) (cid.Cid, error) {
+	var linkList = make([]*linkAndSize, 0)
+	var needAdd = make([]format.Node, 0)
+
 	for len(links) > 1 {
 		var nd *merkledag.ProtoNode = unixfs.EmptyFileNode()
 		nd.SetCidBuilder(cidBuilder)
@@ -157,79 +385,173 @@ func buildCidByLinks(ctx context.Context, links []*linkAndSize, dagServ format.D
 		linkList = make([]*linkAndSize, 0)
 	}
 
+	root := links[0].Link.Cid
+	if !meta.IsEmpty() {
+		// the root is the last node built, or the only leaf
+		nd := first
+		if len(needAdd) > 0 {
+			nd = needAdd[len(needAdd)-1]
+			needAdd = needAdd[:len(needAdd)-1]
+		}
+		nd, err := withMeta(nd, meta, cidBuilder)
+		if err != nil {
+			return cid.Undef, err
+		}
+		needAdd = append(needAdd, nd)
+		root = nd.Cid()
+	}
 	if len(needAdd) > 0 {
 		if err := dagServ.AddMany(ctx, needAdd); err != nil {
 			log.Error(err)
 			return cid.Undef, err
 		}
 	}
-	return links[0].Link.Cid, nil
+	return root, nil
 }
 
 // Todos:
 //  read more bytes and parallel the dags save work
-func BalanceNode(ctx context.Context, f io.Reader, fsize int64, bufDs format.DAGService, cidBuilder cid.Builder, batchReadNum int) (cid.Cid, error) {
-	cker := NewBatchSplitter(f, int64(UnixfsChunkSize), batchReadNum)
-	dataLinks := make([]*linkAndSize, dataLinkNum(fsize, int64(UnixfsChunkSize)))
+func BalanceNode(ctx context.Context, f io.Reader, fsize int64, bufDs format.DAGService, opts api.ImportOpts, batchReadNum int) (cid.Cid, error) {
+	return balanceNode(ctx, f, fsize, bufDs, opts, batchReadNum, nil, FileMeta{})
+}
+
+// balanceNode is BalanceNode which records every completed batch of leaves
+// in cp, and continues after the leaves already in cp. f should be
+// positioned at the offset of cp. A non empty meta is recorded on the root
+func balanceNode(ctx context.Context, f io.Reader, fsize int64, bufDs format.DAGService, opts api.ImportOpts, batchReadNum int, cp *importCheckpoint, meta FileMeta) (cid.Cid, error) {
+	cidBuilder, err := NewCidBuilder(opts.CidVersion, opts.HashFunc)
+	if err != nil {
+		return cid.Undef, err
+	}
+	// trickle dags use unixfs raw type for protobuf leaves, same as go-unixfs
+	leafType := pb.Data_File
+	if opts.Layout == LayoutTrickle {
+		leafType = pb.Data_Raw
+	}
+	// leaves of no-copy imports refer to the source file
+	var fileInfo files.FileInfo
+	if opts.NoCopy {
+		fi, ok := f.(files.FileInfo)
+		if !ok {
+			return cid.Undef, ihelper.ErrMissingFsRef
+		}
+		fileInfo = fi
+	}
+	var startIdx int
+	var startOffset uint64
+	if cp != nil {
+		startIdx, startOffset = len(cp.links), cp.offset
+	}
+	cker, err := newBatchChunkerAt(f, opts.Chunker, batchReadNum, startIdx, startOffset)
+	if err != nil {
+		return cid.Undef, err
+	}
+	// the number of chunks is only known for fixed size chunker
+	dataLinks := make([]*linkAndSize, 0, dataLinkNum(fsize, int64(UnixfsChunkSize)))
+	if cp != nil {
+		for _, lk := range cp.links {
+			dataLinks = append(dataLinks, &linkAndSize{
+				Link:     lk.Link,
+				FileSize: lk.FileSize,
+			})
+		}
+	}
+	// the first leaf is the root of a single chunk file
+	var firstLeaf format.Node
+	// once balanceNode returns, the workers still running give up on cctx
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	errchan := make(chan error)
 	finishedchan := make(chan struct{})
 	linkchan := make(chan IdxLink)
-	go func(linkchan chan IdxLink, finishedchan chan struct{}, errchan chan error) {
+	fail := func(err error) {
+		select {
+		case errchan <- err:
+		case <-cctx.Done():
+		}
+	}
+	go func(linkchan chan IdxLink, finishedchan chan struct{}) {
 		for {
-			select {
-			case <-ctx.Done():
-				errchan <- ctx.Err()
+			if cctx.Err() != nil {
 				return
-			default:
 			}
 			rd, err := cker.NextBytes()
 			if err == io.EOF {
-				finishedchan <- struct{}{}
+				close(finishedchan)
 				return
 			}
 			if err != nil {
-				errchan <- err
+				fail(err)
 				return
 			}
+			batchLinks := make([]*IdxLink, len(rd))
 			wg := sync.WaitGroup{}
 			wg.Add(len(rd))
-			for _, idxbuf := range rd {
-				go func(ib *Idxbuf) {
+			for i, idxbuf := range rd {
+				go func(i int, ib *Idxbuf) {
 					defer wg.Done()
-					fmt.Printf("id: %d, size: %d\n", ib.Idx, len(ib.Buf))
-					dag, err := NewDagWithData(ib.Buf, pb.Data_File, cidBuilder)
+					dag, err := newLeafNode(ib.Buf, leafType, cidBuilder, opts.RawLeaves)
 					if err != nil {
-						errchan <- err
+						fail(err)
 						return
 					}
-					if err = bufDs.Add(ctx, dag); err != nil {
-						errchan <- err
+					if ib.Idx == 0 {
+						firstLeaf = dag
+					}
+					if fileInfo != nil {
+						dag = filestoreNode(dag, ib.Offset, fileInfo)
+					}
+					if err = bufDs.Add(cctx, dag); err != nil {
+						fail(err)
 						return
 					}
 					link, err := format.MakeLink(dag)
 					if err != nil {
-						errchan <- err
+						fail(err)
 						return
 					}
-					linkchan <- IdxLink{
+					lk := IdxLink{
 						Idx:      ib.Idx,
 						Link:     link,
 						FileSize: uint64(len(ib.Buf)),
 					}
-				}(idxbuf)
+					select {
+					case linkchan <- lk:
+						batchLinks[i] = &lk
+					case <-cctx.Done():
+					}
+				}(i, idxbuf)
 			}
 			wg.Wait()
+			for _, lk := range batchLinks {
+				// the failed leaf has reported its error
+				if lk == nil {
+					return
+				}
+			}
+			if cp != nil {
+				last := rd[len(rd)-1]
+				if err := cp.save(batchLinks, last.Offset+uint64(len(last.Buf))); err != nil {
+					fail(err)
+					return
+				}
+			}
 		}
 
-	}(linkchan, finishedchan, errchan)
+	}(linkchan, finishedchan)
 lab:
 	for {
 		select {
+		case <-ctx.Done():
+			return cid.Undef, ctx.Err()
 		case err := <-errchan:
 			return cid.Undef, err
 		case <-finishedchan:
 			break lab
 		case lk := <-linkchan:
+			for len(dataLinks) <= lk.Idx {
+				dataLinks = append(dataLinks, nil)
+			}
 			dataLinks[lk.Idx] = &linkAndSize{
 				Link:     lk.Link,
 				FileSize: lk.FileSize,
@@ -243,7 +565,37 @@ lab:
 		//log.Infof("index: %d, bytes len: %d", i, l.FileSize)
 
 	}
-	ciid, err := buildCidByLinks(ctx, dataLinks, bufDs)
+	if opts.Layout == LayoutTrickle {
+		return buildTrickleByLinks(ctx, dataLinks, bufDs, cidBuilder, maxLinks(opts), meta)
+	}
+	if len(dataLinks) == 0 {
+		// same as go-ipfs, an empty file is a single empty leaf
+		dag, err := newLeafNode(nil, leafType, cidBuilder, opts.RawLeaves)
+		if err != nil {
+			return cid.Undef, err
+		}
+		// a raw leaf is wrapped to carry meta, it is kept below the root
+		if err = bufDs.Add(ctx, dag); err != nil {
+			return cid.Undef, err
+		}
+		if meta.IsEmpty() {
+			return dag.Cid(), nil
+		}
+		if dag, err = withMeta(dag, meta, cidBuilder); err != nil {
+			return cid.Undef, err
+		}
+		if err = bufDs.Add(ctx, dag); err != nil {
+			return cid.Undef, err
+		}
+		return dag.Cid(), nil
+	}
+	if len(dataLinks) == 1 && !meta.IsEmpty() && firstLeaf == nil {
+		// the leaf was added before the import was resumed
+		if firstLeaf, err = bufDs.Get(ctx, dataLinks[0].Link.Cid); err != nil {
+			return cid.Undef, err
+		}
+	}
+	ciid, err := buildCidByLinks(ctx, dataLinks, firstLeaf, bufDs, cidBuilder, maxLinks(opts), meta)
 	if err != nil {
 		return cid.Undef, err
 	}
@@ -265,8 +617,95 @@ type IdxLink struct {
 }
 
 type Idxbuf struct {
-	Idx int
-	Buf []byte
+	Idx    int
+	Offset uint64
+	Buf    []byte
+}
+
+// BatchChunker produces chunks in batches, chunks of a batch are written in
+// parallel by BalanceNode
+type BatchChunker interface {
+	NextBytes() ([]*Idxbuf, error)
+}
+
+// NewBatchChunker returns a BatchChunker for the chunker spec. Fixed size
+// chunks are read with a single read per batch, other chunkers are driven
+// chunk by chunk
+func NewBatchChunker(r io.Reader, spec string, batch int) (BatchChunker, error) {
+	return newBatchChunkerAt(r, spec, batch, 0, 0)
+}
+
+// newBatchChunkerAt returns a BatchChunker whose first chunk has the index
+// idx and starts at offset of the file, r should be positioned at offset
+func newBatchChunkerAt(r io.Reader, spec string, batch int, idx int, offset uint64) (BatchChunker, error) {
+	if spec == "" {
+		bs := NewBatchSplitter(r, int64(UnixfsChunkSize), batch)
+		bs.lastidx, bs.offset = idx, offset
+		return bs, nil
+	}
+	if strings.HasPrefix(spec, "size-") {
+		size, err := strconv.ParseInt(strings.TrimPrefix(spec, "size-"), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size <= 0 {
+			return nil, chunker.ErrSize
+		}
+		if size > int64(chunker.ChunkSizeLimit) {
+			return nil, chunker.ErrSizeMax
+		}
+		bs := NewBatchSplitter(r, size, batch)
+		bs.lastidx, bs.offset = idx, offset
+		return bs, nil
+	}
+	spl, err := chunker.FromString(r, spec)
+	if err != nil {
+		return nil, err
+	}
+	return &SplitterBatch{
+		spl:     spl,
+		batch:   batch,
+		lastidx: idx,
+		offset:  offset,
+	}, nil
+}
+
+// SplitterBatch groups the chunks of a chunker.Splitter into batches
+type SplitterBatch struct {
+	spl     chunker.Splitter
+	batch   int
+	err     error
+	lastidx int
+	offset  uint64
+}
+
+// NextBytes produces the next batch of chunks.
+func (sb *SplitterBatch) NextBytes() ([]*Idxbuf, error) {
+	if sb.err != nil {
+		return nil, sb.err
+	}
+	res := make([]*Idxbuf, 0, sb.batch)
+	for len(res) < sb.batch {
+		buf, err := sb.spl.NextBytes()
+		if err == io.EOF {
+			sb.err = io.EOF
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, &Idxbuf{
+			Idx:    sb.lastidx,
+			Offset: sb.offset,
+			Buf:    buf,
+		})
+		sb.lastidx++
+		sb.offset += uint64(len(buf))
+	}
+	if len(res) == 0 {
+		return nil, io.EOF
+	}
+	return res, nil
 }
 
 type BatchSplitter struct {
@@ -275,6 +714,7 @@ type BatchSplitter struct {
 	batch   uint32
 	err     error
 	lastidx int
+	offset  uint64
 }
 
 // NewSizeSplitter returns a new size-based Splitter with the given block size.
@@ -328,19 +768,23 @@ func (ss *BatchSplitter) indexedbuf(buf []byte) ([]*Idxbuf, error) {
 		buflen := len(buf)
 		if buflen <= int(ss.size) {
 			res = append(res, &Idxbuf{
-				Idx: ss.lastidx,
-				Buf: buf,
+				Idx:    ss.lastidx,
+				Offset: ss.offset,
+				Buf:    buf,
 			})
 			ss.lastidx++
+			ss.offset += uint64(buflen)
 			break
 		}
 		nxtbuf := make([]byte, ss.size)
 		copy(nxtbuf, buf)
 		res = append(res, &Idxbuf{
-			Idx: ss.lastidx,
-			Buf: nxtbuf,
+			Idx:    ss.lastidx,
+			Offset: ss.offset,
+			Buf:    nxtbuf,
 		})
 		ss.lastidx++
+		ss.offset += uint64(ss.size)
 		buf = buf[ss.size:]
 
 	}
@@ -351,3 +795,95 @@ func (ss *BatchSplitter) indexedbuf(buf []byte) ([]*Idxbuf, error) {
 func (ss *BatchSplitter) Reader() io.Reader {
 	return ss.r
 }
+
+// ctxDAG writes with ctx whatever the context of the caller, so that
+// canceling an import stops the writes of the go-unixfs layouts
+type ctxDAG struct {
+	format.DAGService
+	ctx context.Context
+}
+
+func (d *ctxDAG) Add(_ context.Context, nd format.Node) error {
+	if err := d.ctx.Err(); err != nil {
+		return err
+	}
+	return d.DAGService.Add(d.ctx, nd)
+}
+
+func (d *ctxDAG) AddMany(_ context.Context, nds []format.Node) error {
+	if err := d.ctx.Err(); err != nil {
+		return err
+	}
+	return d.DAGService.AddMany(d.ctx, nds)
+}
+
+// DiscardDAG is a DAGService which drops every node added to it, it counts
+// the unique blocks and bytes which would have been stored
+type DiscardDAG struct {
+	lk     sync.Mutex
+	seen   *cid.Set
+	blocks int64
+	bytes  int64
+}
+
+var _ format.DAGService = &DiscardDAG{}
+
+func NewDiscardDAG() *DiscardDAG {
+	return &DiscardDAG{
+		seen: cid.NewSet(),
+	}
+}
+
+func (dd *DiscardDAG) Get(ctx context.Context, c cid.Cid) (format.Node, error) {
+	return nil, format.ErrNotFound
+}
+
+func (dd *DiscardDAG) GetMany(ctx context.Context, cids []cid.Cid) <-chan *format.NodeOption {
+	out := make(chan *format.NodeOption, len(cids))
+	for range cids {
+		out <- &format.NodeOption{Err: format.ErrNotFound}
+	}
+	close(out)
+	return out
+}
+
+func (dd *DiscardDAG) Add(ctx context.Context, nd format.Node) error {
+	dd.lk.Lock()
+	defer dd.lk.Unlock()
+	if dd.seen.Visit(nd.Cid()) {
+		dd.blocks++
+		dd.bytes += int64(len(nd.RawData()))
+	}
+	return nil
+}
+
+func (dd *DiscardDAG) AddMany(ctx context.Context, nds []format.Node) error {
+	for _, nd := range nds {
+		if err := dd.Add(ctx, nd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (dd *DiscardDAG) Remove(ctx context.Context, c cid.Cid) error {
+	return nil
+}
+
+func (dd *DiscardDAG) RemoveMany(ctx context.Context, cids []cid.Cid) error {
+	return nil
+}
+
+// Blocks returns the number of unique blocks added
+func (dd *DiscardDAG) Blocks() int64 {
+	dd.lk.Lock()
+	defer dd.lk.Unlock()
+	return dd.blocks
+}
+
+// Bytes returns the total size of unique blocks added
+func (dd *DiscardDAG) Bytes() int64 {
+	dd.lk.Lock()
+	defer dd.lk.Unlock()
+	return dd.bytes
+}
@@ -0,0 +1,234 @@
+package impl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/filedrive-team/filejoy/api"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	ihelper "github.com/ipfs/go-unixfs/importer/helpers"
+	"github.com/ipfs/go-unixfs/importer/trickle"
+)
+
+func TestTrickleMatchesGoUnixfs(t *testing.T) {
+	n := newTestNode(t)
+	for _, size := range []int{0, 1, 1 << 10, 5<<10 + 17, 64 << 10, 300<<10 + 5} {
+		for _, chunker := range []string{"size-1024", "size-4096", "rabin-512-1024-2048"} {
+			for _, links := range []int{2, 3, 7, 0} {
+				for _, rawLeaves := range []bool{false, true} {
+					opts := api.ImportOpts{
+						Layout:     LayoutTrickle,
+						Chunker:    chunker,
+						MaxLinks:   links,
+						RawLeaves:  rawLeaves,
+						CidVersion: 1,
+					}
+					t.Run(fmt.Sprintf("%d/%s/%d/%t", size, chunker, links, rawLeaves), func(t *testing.T) {
+						data := randData(int64(size), size)
+						c, err := BalanceNode(context.Background(), bytes.NewReader(data), int64(size), n.Dagserv, opts, 8)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if expected := goUnixfsTrickle(t, n.Dagserv, data, opts); !c.Equals(expected) {
+							t.Errorf("root %s, go-unixfs builds %s", c, expected)
+						}
+					})
+				}
+			}
+		}
+	}
+}
+
+// goUnixfsTrickle returns the root of the trickle dag built by go-unixfs
+func goUnixfsTrickle(t *testing.T, dagServ format.DAGService, data []byte, opts api.ImportOpts) cid.Cid {
+	t.Helper()
+	cidBuilder, err := NewCidBuilder(opts.CidVersion, opts.HashFunc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spl, err := NewSplitter(bytes.NewReader(data), opts.Chunker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := (&ihelper.DagBuilderParams{
+		Maxlinks:   maxLinks(opts),
+		RawLeaves:  opts.RawLeaves,
+		CidBuilder: cidBuilder,
+		Dagserv:    dagServ,
+	}).New(spl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, err := trickle.Layout(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nd.Cid()
+}
+
+func TestBalanceNodeFailureStopsWorkers(t *testing.T) {
+	n := newTestNode(t)
+	before := runtime.NumGoroutine()
+	// most leaves of the first batch fail
+	dagServ := &failingDAG{DAGService: n.Dagserv, limit: 2}
+	data := randData(1, 64<<10)
+	_, err := BalanceNode(context.Background(), bytes.NewReader(data), int64(len(data)), dagServ, smallChunks, 32)
+	if err != errDAGFull {
+		t.Fatalf("import: %v, expected %v", err, errDAGFull)
+	}
+	for deadline := time.Now().Add(5 * time.Second); runtime.NumGoroutine() > before; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines left running after the import failed", runtime.NumGoroutine()-before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAddEmptyFileWithMeta(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "empty")
+	if err := os.WriteFile(src, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(src, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	for _, rawLeaves := range []bool{false, true} {
+		t.Run(fmt.Sprintf("raw-leaves=%t", rawLeaves), func(t *testing.T) {
+			n := newTestNode(t)
+			a := &CommonAPI{Node: n}
+			c := addedCid(t, add2(t, a, src, api.ImportOpts{
+				RawLeaves:     rawLeaves,
+				PreserveMode:  true,
+				PreserveMtime: true,
+			}))
+			res, err := (&DagAPI{Node: n}).DagCheck(context.Background(), c, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !res.Complete {
+				t.Fatalf("dag of the empty file misses %v", res.MissingCids)
+			}
+
+			dst := filepath.Join(t.TempDir(), "empty")
+			getTree(t, a, c, dst, api.GetOpts{})
+			fi, err := os.Stat(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Size() != 0 || fi.Mode().Perm() != 0600 || !fi.ModTime().Equal(mtime) {
+				t.Errorf("got a file of %d bytes, mode %s, mtime %s", fi.Size(), fi.Mode(), fi.ModTime())
+			}
+		})
+	}
+}
+
+// getTree runs Get to the end and fails the test if it fails
+func getTree(t *testing.T, a *CommonAPI, c cid.Cid, path string, opts api.GetOpts) api.PBar {
+	t.Helper()
+	out, err := a.Get(context.Background(), c, path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last api.PBar
+	for pb := range out {
+		last = pb
+	}
+	if last.Err != "" {
+		t.Fatalf("get %s: %s", c, last.Err)
+	}
+	return last
+}
+
+func TestGetVerify(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "data")
+	if err := os.WriteFile(src, randData(1, 2<<20+300<<10), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name string
+		opts api.ImportOpts
+		// inferred is set if the options can be told from the dag
+		inferred bool
+	}{
+		{"default", api.ImportOpts{}, true},
+		{"trickle", api.ImportOpts{Layout: LayoutTrickle}, true},
+		{"trickle-deep", api.ImportOpts{Layout: LayoutTrickle, Chunker: "size-1024", RawLeaves: true}, true},
+		{"rabin", api.ImportOpts{Chunker: "rabin-2048-8192-32768", CidVersion: 1}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			n := newTestNode(t)
+			a := &CommonAPI{Node: n}
+			c := addedCid(t, add2(t, a, src, tc.opts))
+			dst := filepath.Join(t.TempDir(), "data")
+			if res := getTree(t, a, c, dst, api.GetOpts{Verify: true}); res.Msg != fmt.Sprintf("Verified: %s", c) {
+				t.Fatalf("get reported %q", res.Msg)
+			}
+			nd, err := n.Dagserv.Get(context.Background(), c)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// files imported elsewhere have no recorded options
+			if err := n.Datastore.Delete(importOptsPrefix.ChildString(c.String())); err != nil {
+				t.Fatal(err)
+			}
+			err = verifyFile(context.Background(), n.Datastore, n.Dagserv, nd, dst)
+			if tc.inferred && err != nil {
+				t.Errorf("verify with the options told from the dag: %v", err)
+			}
+			if !tc.inferred && (err == nil || !strings.Contains(err.Error(), "cannot verify")) {
+				t.Errorf("verify with unknown options: %v", err)
+			}
+
+			// a changed file is reported as corrupted against the recorded
+			// options
+			if err := saveImportOpts(n.Datastore, c, tc.opts); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.OpenFile(dst, os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.WriteAt([]byte("changed"), 1<<20); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+			err = verifyFile(context.Background(), n.Datastore, n.Dagserv, nd, dst)
+			if err == nil || !strings.Contains(err.Error(), "corrupted") {
+				t.Errorf("verify of a changed file: %v", err)
+			}
+		})
+	}
+}
+
+func TestBuildFileNodeCancelled(t *testing.T) {
+	n := newTestNode(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, layout := range []string{LayoutBalanced, LayoutTrickle} {
+		opts := smallChunks
+		opts.Layout = layout
+		_, err := BuildFileNode(ctx, bytes.NewReader(randData(1, 10<<10)), n.Dagserv, opts, FileMeta{Mode: 0644})
+		if err != context.Canceled {
+			t.Errorf("%s import with a cancelled context: %v", layout, err)
+		}
+	}
+	keys, err := n.Blockstore.AllKeysChan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for c := range keys {
+		t.Errorf("block %s stored by a cancelled import", c)
+	}
+}
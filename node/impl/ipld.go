@@ -0,0 +1,178 @@
+package impl
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+
+	"github.com/filedrive-team/filejoy/api"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/multicodec"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"golang.org/x/xerrors"
+
+	// register the codecs dag put and get work with
+	_ "github.com/ipld/go-codec-dagpb"
+	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	_ "github.com/ipld/go-ipld-prime/codec/raw"
+)
+
+// dagCodecs are the multicodecs dag put accepts, by name
+var dagCodecs = map[string]uint64{
+	"dag-pb":   cid.DagProtobuf,
+	"dag-cbor": cid.DagCBOR,
+	"dag-json": 0x0129,
+	"raw":      cid.Raw,
+}
+
+// dagCodec returns the multicodec of name, or def if name is empty
+func dagCodec(name, def string) (uint64, error) {
+	if name == "" {
+		name = def
+	}
+	code, ok := dagCodecs[strings.ToLower(name)]
+	if !ok {
+		return 0, xerrors.Errorf("unsupported codec %s", name)
+	}
+	return code, nil
+}
+
+// linkSystem returns an ipld link system which loads blocks through the
+// node's blockservice, fetching missing ones from the network, and stores
+// blocks in the blockstore
+func (a *DagAPI) linkSystem() linking.LinkSystem {
+	bs := blockservice.New(a.Node.Blockstore, a.Node.Bitswap)
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.StorageReadOpener = func(lctx linking.LinkContext, lnk datamodel.Link) (io.Reader, error) {
+		blk, err := bs.GetBlock(lctx.Ctx, lnk.(cidlink.Link).Cid)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(blk.RawData()), nil
+	}
+	lsys.StorageWriteOpener = func(lctx linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		buf := new(bytes.Buffer)
+		return buf, func(lnk datamodel.Link) error {
+			blk, err := blocks.NewBlockWithCid(buf.Bytes(), lnk.(cidlink.Link).Cid)
+			if err != nil {
+				return err
+			}
+			return bs.AddBlock(blk)
+		}, nil
+	}
+	return lsys
+}
+
+// resolve walks p, a cid followed by path segments, e.g. <cid>/a/0/b. Links
+// met on the way are followed, including one at the end of p. It returns
+// the node at p, the cid of the block holding it and the segments left
+// inside that block
+func (a *DagAPI) resolve(ctx context.Context, p string) (datamodel.Node, cid.Cid, []string, error) {
+	p = strings.TrimPrefix(strings.TrimPrefix(p, "/ipfs/"), "/")
+	segs := strings.Split(strings.TrimSuffix(p, "/"), "/")
+	c, err := cid.Decode(segs[0])
+	if err != nil {
+		return nil, cid.Undef, nil, err
+	}
+	lsys := a.linkSystem()
+	lctx := linking.LinkContext{Ctx: ctx}
+	nd, err := lsys.Load(lctx, cidlink.Link{Cid: c}, basicnode.Prototype.Any)
+	if err != nil {
+		return nil, cid.Undef, nil, xerrors.Errorf("load %s: %w", c, err)
+	}
+	var rem []string
+	for _, seg := range append(segs[1:], "") {
+		if nd.Kind() == datamodel.Kind_Link {
+			lnk, err := nd.AsLink()
+			if err != nil {
+				return nil, cid.Undef, nil, err
+			}
+			c = lnk.(cidlink.Link).Cid
+			if nd, err = lsys.Load(lctx, lnk, basicnode.Prototype.Any); err != nil {
+				return nil, cid.Undef, nil, xerrors.Errorf("load %s: %w", c, err)
+			}
+			rem = rem[:0]
+		}
+		// the empty segment only follows a trailing link
+		if seg == "" {
+			continue
+		}
+		if nd, err = nd.LookupBySegment(datamodel.ParsePathSegment(seg)); err != nil {
+			return nil, cid.Undef, nil, xerrors.Errorf("resolve %s in %s: %w", seg, c, err)
+		}
+		rem = append(rem, seg)
+	}
+	return nd, c, rem, nil
+}
+
+// DagGet returns the node at p encoded as dag-json
+func (a *DagAPI) DagGet(ctx context.Context, p string) ([]byte, error) {
+	nd, _, _, err := a.resolve(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := dagjson.Encode(nd, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DagPut decodes data with the input codec and stores it as a block of the
+// store codec, it returns the cid of the block
+func (a *DagAPI) DagPut(ctx context.Context, data []byte, opts api.DagPutOpts) (cid.Cid, error) {
+	inCodec, err := dagCodec(opts.InputCodec, "dag-json")
+	if err != nil {
+		return cid.Undef, err
+	}
+	storeCodec, err := dagCodec(opts.StoreCodec, "dag-cbor")
+	if err != nil {
+		return cid.Undef, err
+	}
+	builder, err := NewCidBuilder(1, opts.HashFunc)
+	if err != nil {
+		return cid.Undef, err
+	}
+	prefix := builder.(cid.Prefix)
+	prefix.Codec = storeCodec
+
+	decode, err := multicodec.LookupDecoder(inCodec)
+	if err != nil {
+		return cid.Undef, err
+	}
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := decode(nb, bytes.NewReader(data)); err != nil {
+		return cid.Undef, xerrors.Errorf("decode input: %w", err)
+	}
+	defer a.Node.GCLocker.PinLock().Unlock()
+	lsys := a.linkSystem()
+	lnk, err := lsys.Store(linking.LinkContext{Ctx: ctx}, cidlink.LinkPrototype{Prefix: prefix}, nb.Build())
+	if err != nil {
+		return cid.Undef, err
+	}
+	c := lnk.(cidlink.Link).Cid
+	if err := pinRoot(a.Node, c, opts.Pin); err != nil {
+		return cid.Undef, err
+	}
+	return c, nil
+}
+
+// DagResolve returns the cid of the block holding the node at p and the
+// path left inside that block
+func (a *DagAPI) DagResolve(ctx context.Context, p string) (api.DagResolved, error) {
+	_, c, rem, err := a.resolve(ctx, p)
+	if err != nil {
+		return api.DagResolved{}, err
+	}
+	return api.DagResolved{
+		Cid:     c,
+		RemPath: strings.Join(rem, "/"),
+	}, nil
+}
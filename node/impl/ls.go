@@ -0,0 +1,179 @@
+package impl
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/filedrive-team/filejoy/api"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+	ufsio "github.com/ipfs/go-unixfs/io"
+	"golang.org/x/xerrors"
+)
+
+// type names of ls entries
+const (
+	lsTypeFile    = "file"
+	lsTypeDir     = "dir"
+	lsTypeSymlink = "symlink"
+	lsTypeRaw     = "raw"
+)
+
+var kindNames = map[int]string{
+	kindFile:    lsTypeFile,
+	kindDir:     lsTypeDir,
+	kindSymlink: lsTypeSymlink,
+}
+
+// resolvePath returns the node at p, a cid optionally followed by the names
+// of directory entries, e.g. <cid>/sub/dir. A leading /ipfs/ is ignored
+func resolvePath(ctx context.Context, dagServ format.DAGService, p string) (format.Node, error) {
+	p = strings.TrimPrefix(strings.TrimPrefix(p, "/ipfs/"), "/")
+	parts := strings.Split(strings.TrimSuffix(p, "/"), "/")
+	c, err := cid.Decode(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	nd, err := dagServ.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	for i, name := range parts[1:] {
+		if name == "" {
+			continue
+		}
+		kind, _, err := unixfsKind(nd)
+		if err != nil {
+			return nil, err
+		}
+		if kind != kindDir {
+			return nil, xerrors.Errorf("%s is not a directory", strings.Join(parts[:i+1], "/"))
+		}
+		dir, err := ufsio.NewDirectoryFromNode(dagServ, nd)
+		if err != nil {
+			return nil, err
+		}
+		if nd, err = dir.Find(ctx, name); err != nil {
+			return nil, xerrors.Errorf("resolve %s: %w", strings.Join(parts[:i+2], "/"), err)
+		}
+	}
+	return nd, nil
+}
+
+// lsEntry describes nd as an entry named name
+func lsEntry(name string, nd format.Node) (api.LsEntry, error) {
+	kind, fsn, err := unixfsKind(nd)
+	if err != nil {
+		return api.LsEntry{}, err
+	}
+	ent := api.LsEntry{
+		Name: name,
+		Cid:  nd.Cid(),
+		Type: kindNames[kind],
+	}
+	switch kind {
+	case kindFile:
+		ent.Size = fileSize(nd, fsn)
+	case kindDir:
+		ent.Size, err = nd.Size()
+	case kindSymlink:
+		ent.Size = uint64(len(fsn.Data()))
+	}
+	return ent, err
+}
+
+// fileChunks returns the child links of the file nd as entries with their
+// offsets in the file
+func fileChunks(nd format.Node) ([]api.LsEntry, error) {
+	pn, ok := nd.(*merkledag.ProtoNode)
+	if !ok {
+		return nil, nil
+	}
+	fsn, err := unixfs.FSNodeFromBytes(pn.Data())
+	if err != nil {
+		return nil, err
+	}
+	sizes := fsn.BlockSizes()
+	if len(sizes) != len(pn.Links()) {
+		return nil, xerrors.Errorf("%s has %d links but %d block sizes", nd.Cid(), len(pn.Links()), len(sizes))
+	}
+	offset := uint64(len(fsn.Data()))
+	ents := make([]api.LsEntry, 0, len(sizes))
+	for i, l := range pn.Links() {
+		typ := lsTypeFile
+		if l.Cid.Prefix().Codec == cid.Raw {
+			typ = lsTypeRaw
+		}
+		ents = append(ents, api.LsEntry{
+			Cid:    l.Cid,
+			Type:   typ,
+			Size:   sizes[i],
+			Offset: offset,
+		})
+		offset += sizes[i]
+	}
+	return ents, nil
+}
+
+// Ls lists the entries of the directory at p, a file or symlink is listed
+// as itself. With chunks, the chunk links of a file are listed instead
+func (a *CommonAPI) Ls(ctx context.Context, p string, chunks bool) (chan api.LsEntry, error) {
+	nd, err := resolvePath(ctx, a.Node.Dagserv, p)
+	if err != nil {
+		return nil, err
+	}
+	kind, _, err := unixfsKind(nd)
+	if err != nil {
+		return nil, err
+	}
+	var ents []api.LsEntry
+	switch {
+	case kind == kindFile && chunks:
+		if ents, err = fileChunks(nd); err != nil {
+			return nil, err
+		}
+	case kind != kindDir:
+		ent, err := lsEntry(path.Base(p), nd)
+		if err != nil {
+			return nil, err
+		}
+		ents = append(ents, ent)
+	}
+
+	out := make(chan api.LsEntry)
+	go func() {
+		defer close(out)
+		send := func(ent api.LsEntry) error {
+			select {
+			case out <- ent:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if kind != kindDir {
+			for _, ent := range ents {
+				if send(ent) != nil {
+					return
+				}
+			}
+			return
+		}
+		// directories may be sharded over many blocks, stream their entries
+		err := forEachEntry(ctx, a.Node.Dagserv, nd, func(name string, child format.Node) error {
+			ent, err := lsEntry(name, child)
+			if err != nil {
+				return err
+			}
+			return send(ent)
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Warnf("ls %s: %s", p, err)
+			send(api.LsEntry{Err: err.Error()})
+		}
+	}()
+	return out, nil
+}
@@ -0,0 +1,225 @@
+package impl
+
+import (
+	"os"
+	"time"
+
+	"github.com/filedrive-team/filejoy/api"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	pb "github.com/ipfs/go-unixfs/pb"
+	"golang.org/x/xerrors"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// unixfs 1.5 fields of the Data message, go-unixfs keeps them as unknown
+// fields when it decodes and encodes the data
+const (
+	unixfsModeField  protowire.Number = 7
+	unixfsMtimeField protowire.Number = 8
+
+	unixTimeSecondsField protowire.Number = 1
+	unixTimeNanosField   protowire.Number = 2
+)
+
+// posix permission and special bits, the only mode bits kept in unixfs
+const (
+	modeSetuid = 04000
+	modeSetgid = 02000
+	modeSticky = 01000
+)
+
+// FileMeta is the unixfs 1.5 metadata of a file or directory
+type FileMeta struct {
+	// Mode holds the posix permission and special bits, 0 means unset
+	Mode uint32
+	// ModTime is the modification time, the zero time means unset
+	ModTime time.Time
+}
+
+// IsEmpty reports whether no metadata is set
+func (m FileMeta) IsEmpty() bool {
+	return m.Mode == 0 && m.ModTime.IsZero()
+}
+
+// FileMode returns Mode as os.FileMode, without the file type bits
+func (m FileMeta) FileMode() os.FileMode {
+	mode := os.FileMode(m.Mode & 0777)
+	if m.Mode&modeSetuid != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m.Mode&modeSetgid != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m.Mode&modeSticky != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// PosixMode converts the permission and special bits of mode to posix bits
+func PosixMode(mode os.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		m |= modeSetuid
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= modeSetgid
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= modeSticky
+	}
+	return m
+}
+
+// fileMeta returns the metadata of finfo which opts asks to preserve
+func fileMeta(finfo os.FileInfo, opts api.ImportOpts) FileMeta {
+	var meta FileMeta
+	if opts.PreserveMode {
+		meta.Mode = PosixMode(finfo.Mode())
+	}
+	if opts.PreserveMtime {
+		meta.ModTime = finfo.ModTime()
+	}
+	return meta
+}
+
+// restoreMeta applies meta to the file or directory at path, unset fields
+// are left as they are
+func restoreMeta(path string, meta FileMeta) error {
+	if meta.Mode != 0 {
+		if err := os.Chmod(path, meta.FileMode()); err != nil {
+			return err
+		}
+	}
+	if !meta.ModTime.IsZero() {
+		if err := os.Chtimes(path, meta.ModTime, meta.ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetMetaData returns the unixfs data with the metadata replaced by meta
+func SetMetaData(data []byte, meta FileMeta) ([]byte, error) {
+	res := make([]byte, 0, len(data)+24)
+	for b := data; len(b) > 0; {
+		num, _, n := protowire.ConsumeField(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		if num != unixfsModeField && num != unixfsMtimeField {
+			res = append(res, b[:n]...)
+		}
+		b = b[n:]
+	}
+	if meta.Mode != 0 {
+		res = protowire.AppendTag(res, unixfsModeField, protowire.VarintType)
+		res = protowire.AppendVarint(res, uint64(meta.Mode))
+	}
+	if !meta.ModTime.IsZero() {
+		var ut []byte
+		ut = protowire.AppendTag(ut, unixTimeSecondsField, protowire.VarintType)
+		ut = protowire.AppendVarint(ut, uint64(meta.ModTime.Unix()))
+		if nsec := meta.ModTime.Nanosecond(); nsec != 0 {
+			ut = protowire.AppendTag(ut, unixTimeNanosField, protowire.Fixed32Type)
+			ut = protowire.AppendFixed32(ut, uint32(nsec))
+		}
+		res = protowire.AppendTag(res, unixfsMtimeField, protowire.BytesType)
+		res = protowire.AppendBytes(res, ut)
+	}
+	return res, nil
+}
+
+// MetaFromData reads the metadata of unixfs data
+func MetaFromData(data []byte) (FileMeta, error) {
+	var meta FileMeta
+	for b := data; len(b) > 0; {
+		num, typ, n := protowire.ConsumeField(b)
+		if n < 0 {
+			return meta, protowire.ParseError(n)
+		}
+		switch {
+		case num == unixfsModeField && typ == protowire.VarintType:
+			v, _ := protowire.ConsumeVarint(b[protowire.SizeTag(num):])
+			meta.Mode = uint32(v)
+		case num == unixfsMtimeField && typ == protowire.BytesType:
+			ut, _ := protowire.ConsumeBytes(b[protowire.SizeTag(num):])
+			mtime, err := parseUnixTime(ut)
+			if err != nil {
+				return meta, err
+			}
+			meta.ModTime = mtime
+		}
+		b = b[n:]
+	}
+	return meta, nil
+}
+
+func parseUnixTime(b []byte) (time.Time, error) {
+	var sec int64
+	var nsec uint32
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeField(b)
+		if n < 0 {
+			return time.Time{}, protowire.ParseError(n)
+		}
+		switch {
+		case num == unixTimeSecondsField && typ == protowire.VarintType:
+			v, _ := protowire.ConsumeVarint(b[protowire.SizeTag(num):])
+			sec = int64(v)
+		case num == unixTimeNanosField && typ == protowire.Fixed32Type:
+			nsec, _ = protowire.ConsumeFixed32(b[protowire.SizeTag(num):])
+		}
+		b = b[n:]
+	}
+	if nsec > 999999999 {
+		return time.Time{}, xerrors.Errorf("invalid mtime nanoseconds: %d", nsec)
+	}
+	return time.Unix(sec, int64(nsec)), nil
+}
+
+// NodeMeta returns the metadata of a unixfs node, raw nodes have none
+func NodeMeta(nd format.Node) (FileMeta, error) {
+	pn, ok := nd.(*merkledag.ProtoNode)
+	if !ok {
+		return FileMeta{}, nil
+	}
+	return MetaFromData(pn.Data())
+}
+
+// withMeta returns nd carrying meta, a raw node is wrapped in a unixfs file
+// node first. The returned node is not added to the dag service
+func withMeta(nd format.Node, meta FileMeta, cidBuilder cid.Builder) (format.Node, error) {
+	if meta.IsEmpty() {
+		return nd, nil
+	}
+	var pn *merkledag.ProtoNode
+	switch n := nd.(type) {
+	case *merkledag.ProtoNode:
+		pn = n.Copy().(*merkledag.ProtoNode)
+	case *merkledag.RawNode:
+		fn := NewFSNodeOverDag(pb.Data_File, cidBuilder)
+		link, err := format.MakeLink(n)
+		if err != nil {
+			return nil, err
+		}
+		if err := fn.AddChild(link, uint64(len(n.RawData()))); err != nil {
+			return nil, err
+		}
+		wrapped, err := fn.Commit()
+		if err != nil {
+			return nil, err
+		}
+		pn = wrapped.(*merkledag.ProtoNode)
+	default:
+		return nil, xerrors.Errorf("can not set metadata on %T", nd)
+	}
+	data, err := SetMetaData(pn.Data(), meta)
+	if err != nil {
+		return nil, err
+	}
+	pn.SetData(data)
+	return pn, nil
+}
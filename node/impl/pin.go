@@ -0,0 +1,166 @@
+package impl
+
+import (
+	"context"
+
+	"github.com/filedrive-team/filejoy/api"
+	"github.com/filedrive-team/filejoy/node"
+	"github.com/filedrive-team/filejoy/node/pinner"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"golang.org/x/xerrors"
+)
+
+type PinAPI struct {
+	Node *node.Node
+}
+
+// pinFetchConcurrency is the number of blocks fetched at a time for a
+// recursive pin
+const pinFetchConcurrency = 32
+
+// PinAdd pins c, fetching the block, or the whole dag below it if recursive,
+// so that the pin is complete once it is added
+func (a *PinAPI) PinAdd(ctx context.Context, c cid.Cid, recursive bool, opts api.PinOpts) error {
+	defer a.Node.GCLocker.PinLock().Unlock()
+	mode := pinner.Direct
+	if recursive {
+		mode = pinner.Recursive
+		ng := &quotaGetter{
+			NodeGetter: a.Node.Dagserv,
+			bs:         a.Node.Blockstore,
+			q:          a.Node.Quota,
+		}
+		err := walkDag(ctx, ng, []cid.Cid{c}, pinFetchConcurrency, 0, func(c cid.Cid, _ int, _ format.Node, err error) error {
+			if err != nil {
+				return xerrors.Errorf("fetch %s: %w", c, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		if _, err := blockservice.New(a.Node.Blockstore, a.Node.Bitswap).GetBlock(ctx, c); err != nil {
+			return xerrors.Errorf("fetch %s: %w", c, err)
+		}
+	}
+	return a.Node.Pinner.Pin(&pinner.Pin{
+		Cid:    c,
+		Mode:   mode,
+		Name:   opts.Name,
+		Labels: opts.Labels,
+	})
+}
+
+func (a *PinAPI) PinRm(ctx context.Context, c cid.Cid) error {
+	return a.Node.Pinner.Unpin(c)
+}
+
+func (a *PinAPI) PinLs(ctx context.Context, filter api.PinFilter) ([]api.PinInfo, error) {
+	pins, err := a.pins(filter)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]api.PinInfo, 0, len(pins))
+	for _, p := range pins {
+		res = append(res, api.PinInfo{
+			Cid:     p.Cid,
+			Mode:    p.Mode,
+			Name:    p.Name,
+			Labels:  p.Labels,
+			Created: p.Created,
+		})
+	}
+	return res, nil
+}
+
+// PinVerify checks that the blocks kept by the selected pins are all stored
+// locally, nothing is fetched
+func (a *PinAPI) PinVerify(ctx context.Context, filter api.PinFilter) (chan api.PinVerifyRef, error) {
+	pins, err := a.pins(filter)
+	if err != nil {
+		return nil, err
+	}
+	dag := &DagAPI{Node: a.Node}
+	out := make(chan api.PinVerifyRef)
+	go func() {
+		defer close(out)
+		for _, p := range pins {
+			ref := api.PinVerifyRef{
+				Cid:  p.Cid,
+				Mode: p.Mode,
+				Name: p.Name,
+			}
+			if p.Mode == pinner.Recursive {
+				res, err := dag.DagCheck(ctx, p.Cid, 0)
+				if err != nil {
+					ref.Err = err.Error()
+				} else {
+					ref.Complete = res.Complete
+					ref.Blocks = res.Blocks
+					ref.Missing = res.Missing
+				}
+			} else {
+				has, err := a.Node.Blockstore.Has(p.Cid)
+				if err != nil {
+					ref.Err = err.Error()
+				} else if has {
+					ref.Complete = true
+					ref.Blocks = 1
+				} else {
+					ref.Missing = 1
+				}
+			}
+			select {
+			case out <- ref:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// pins returns the pins selected by filter
+func (a *PinAPI) pins(filter api.PinFilter) ([]*pinner.Pin, error) {
+	if filter.Mode != "" && filter.Mode != pinner.Direct && filter.Mode != pinner.Recursive {
+		return nil, xerrors.Errorf("unknown pin mode %q", filter.Mode)
+	}
+	pins, err := a.Node.Pinner.List()
+	if err != nil {
+		return nil, err
+	}
+	res := pins[:0]
+	for _, p := range pins {
+		if (filter.Mode == "" || p.Mode == filter.Mode) && p.Match(filter.Name, filter.Labels) {
+			res = append(res, p)
+		}
+	}
+	return res, nil
+}
+
+// pinRoot pins the dag of the imported root c recursively, nothing is done
+// if opts is nil
+func pinRoot(n *node.Node, c cid.Cid, opts *api.PinOpts) error {
+	return pinCid(n.Pinner, c, opts)
+}
+
+// pinCid pins the dag of c recursively with pins, nothing is done if opts
+// is nil
+func pinCid(pins *pinner.Pinner, c cid.Cid, opts *api.PinOpts) error {
+	if opts == nil {
+		return nil
+	}
+	err := pins.Pin(&pinner.Pin{
+		Cid:    c,
+		Mode:   pinner.Recursive,
+		Name:   opts.Name,
+		Labels: opts.Labels,
+	})
+	if err != nil {
+		return xerrors.Errorf("pin %s: %w", c, err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package impl
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filedrive-team/filejoy/api"
+	"github.com/filedrive-team/filejoy/node/pinner"
+	"github.com/ipfs/go-cid"
+)
+
+func TestPinLsFilter(t *testing.T) {
+	n := newTestNode(t)
+	a := &PinAPI{Node: n}
+	var cids []cid.Cid
+	for i, p := range []*pinner.Pin{
+		{Mode: pinner.Recursive, Name: "photos", Labels: map[string]string{"year": "2021"}},
+		{Mode: pinner.Recursive, Name: "photos", Labels: map[string]string{"year": "2020"}},
+		{Mode: pinner.Direct, Name: "videos", Labels: map[string]string{"year": "2021"}},
+	} {
+		p.Cid = addTestFile(t, n, randData(int64(i), 1<<10), smallChunks)
+		if err := n.Pinner.Pin(p); err != nil {
+			t.Fatal(err)
+		}
+		cids = append(cids, p.Cid)
+	}
+
+	for _, tc := range []struct {
+		filter api.PinFilter
+		pinned []cid.Cid
+	}{
+		{api.PinFilter{}, cids},
+		{api.PinFilter{Mode: pinner.Recursive}, cids[:2]},
+		{api.PinFilter{Mode: pinner.Direct}, cids[2:]},
+		{api.PinFilter{Name: "photos"}, cids[:2]},
+		{api.PinFilter{Labels: map[string]string{"year": "2021"}}, []cid.Cid{cids[0], cids[2]}},
+		{api.PinFilter{Name: "photos", Labels: map[string]string{"year": "2020"}}, cids[1:2]},
+		{api.PinFilter{Mode: pinner.Direct, Name: "photos"}, nil},
+	} {
+		pins, err := a.PinLs(context.Background(), tc.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := cid.NewSet()
+		for _, p := range pins {
+			got.Add(p.Cid)
+		}
+		ok := got.Len() == len(tc.pinned)
+		for _, c := range tc.pinned {
+			ok = ok && got.Has(c)
+		}
+		if !ok {
+			t.Errorf("pins listed with %+v: %v, expected %v", tc.filter, got.Keys(), tc.pinned)
+		}
+	}
+
+	if _, err := a.PinLs(context.Background(), api.PinFilter{Mode: "shallow"}); err == nil {
+		t.Error("unknown pin mode accepted")
+	}
+}
@@ -0,0 +1,372 @@
+package impl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/filedrive-team/filejoy/api"
+	"github.com/filedrive-team/filejoy/node"
+	"github.com/filedrive-team/go-ds-cluster/clusterclient"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	"golang.org/x/xerrors"
+)
+
+type RepoAPI struct {
+	Node *node.Node
+}
+
+var (
+	// fsckCursorKey is where the fsck position is kept in the datastore
+	fsckCursorKey = datastore.NewKey("/repo-fsck/cursor")
+	// fsckQuarantinePrefix is the datastore namespace of the corrupt blocks
+	// moved out of the blockstore, by cid
+	fsckQuarantinePrefix = datastore.NewKey("/repo-fsck/quarantine")
+)
+
+const (
+	// fsckSaveEvery is the number of keys checked between cursor saves
+	fsckSaveEvery = 1000
+	// fsckProgressEvery is the number of keys checked between progress
+	// reports
+	fsckProgressEvery = 10000
+)
+
+// fsckCursor is the position of an interrupted fsck. Keys are checked in
+// the order the blockstore lists them, a resumed fsck skips the first Count
+// keys, the last of which must be Key. Otherwise the listing changed, some
+// keys could be missed, and the fsck starts over
+type fsckCursor struct {
+	Count int64  `json:"count"`
+	Key   string `json:"key"`
+}
+
+// RepoFsck rehashes every block of the blockstore and streams the corrupt
+// and unreadable ones, with a progress report every so often. It resumes
+// from the cursor saved by an interrupted run unless opts.Restart is set
+func (a *RepoAPI) RepoFsck(ctx context.Context, opts api.RepoFsckOpts) (chan api.FsckRef, error) {
+	ds := a.Node.Datastore
+	var cursor fsckCursor
+	if !opts.Restart {
+		v, err := ds.Get(fsckCursorKey)
+		if err == nil {
+			err = json.Unmarshal(v, &cursor)
+		} else if err == datastore.ErrNotFound {
+			err = nil
+		}
+		if err != nil {
+			return nil, xerrors.Errorf("load fsck cursor: %w", err)
+		}
+	}
+	// the listing is canceled if the fsck has to start over
+	kctx, kcancel := context.WithCancel(ctx)
+	keys, err := a.Node.Blockstore.AllKeysChan(kctx)
+	if err != nil {
+		kcancel()
+		return nil, err
+	}
+	concur := opts.Concurrent
+	if concur <= 0 {
+		concur = 1
+	}
+
+	out := make(chan api.FsckRef)
+	go func() {
+		defer close(out)
+		defer func() {
+			kcancel()
+		}()
+		send := func(ref api.FsckRef) bool {
+			select {
+			case out <- ref:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		// skip the keys checked by the previous run
+		var skipped int64
+		var last cid.Cid
+		for skipped < cursor.Count {
+			c, ok := <-keys
+			if !ok {
+				break
+			}
+			skipped++
+			last = c
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if skipped > 0 && (skipped < cursor.Count || last.String() != cursor.Key) {
+			log.Warnf("fsck cursor %s is not at position %d of the blockstore listing, checking from the first key", cursor.Key, cursor.Count)
+			kcancel()
+			kctx, kcancel = context.WithCancel(ctx)
+			keys, err = a.Node.Blockstore.AllKeysChan(kctx)
+			if err != nil {
+				send(api.FsckRef{
+					Status: BlockError,
+					Err:    err.Error(),
+					Done:   true,
+				})
+				return
+			}
+			skipped = 0
+			last = cid.Undef
+		}
+
+		type job struct {
+			pos int64
+			c   cid.Cid
+		}
+		jobs := make(chan job)
+		var (
+			mu sync.Mutex
+			// done holds the checked positions after the first unchecked one
+			done = map[int64]cid.Cid{}
+			next = skipped
+			// lastDone is the key at next-1
+			lastDone = last
+			// saved is the count of the last saved cursor
+			saved   = skipped
+			saveErr error
+		)
+		// advance marks pos as checked and saves the cursor once enough keys
+		// before it have been checked
+		advance := func(pos int64, c cid.Cid) {
+			mu.Lock()
+			defer mu.Unlock()
+			done[pos] = c
+			for {
+				dc, ok := done[next]
+				if !ok {
+					break
+				}
+				delete(done, next)
+				lastDone = dc
+				next++
+			}
+			if next-saved >= fsckSaveEvery && saveErr == nil {
+				saveErr = a.saveFsckCursor(next, lastDone)
+				saved = next
+			}
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(concur)
+		for i := 0; i < concur; i++ {
+			go func() {
+				defer wg.Done()
+				for j := range jobs {
+					ref, bad := a.fsckBlock(j.c, opts.Quarantine)
+					if bad && !send(ref) {
+						// canceled, check it again next time
+						continue
+					}
+					advance(j.pos, j.c)
+				}
+			}()
+		}
+		pos := skipped
+	loop:
+		for c := range keys {
+			select {
+			case jobs <- job{pos: pos, c: c}:
+			case <-ctx.Done():
+				break loop
+			}
+			pos++
+			if pos%fsckProgressEvery == 0 && !send(api.FsckRef{Checked: pos}) {
+				break loop
+			}
+		}
+		close(jobs)
+		wg.Wait()
+
+		mu.Lock()
+		err := saveErr
+		mu.Unlock()
+		if ctx.Err() != nil {
+			// the next run resumes after the keys checked so far
+			if err == nil && next > saved {
+				err = a.saveFsckCursor(next, lastDone)
+			}
+			if err != nil {
+				log.Errorf("save fsck cursor: %s", err)
+			}
+			return
+		}
+		if err == nil {
+			// a complete run starts over next time
+			err = ds.Delete(fsckCursorKey)
+		}
+		res := api.FsckRef{
+			Checked: pos,
+			Done:    true,
+		}
+		if err != nil {
+			res.Status = BlockError
+			res.Err = xerrors.Errorf("save fsck cursor: %w", err).Error()
+		}
+		send(res)
+	}()
+	return out, nil
+}
+
+// fsckBlock rehashes the block c, bad is set if it can not be read or does
+// not match its hash. Corrupt blocks are moved to the quarantine if asked
+func (a *RepoAPI) fsckBlock(c cid.Cid, quarantine bool) (ref api.FsckRef, bad bool) {
+	ref = api.FsckRef{Cid: c}
+	blk, err := a.Node.Blockstore.Get(c)
+	if err != nil {
+		// unreadable blocks are left alone, the backend may only be
+		// unavailable for now
+		ref.Status = BlockError
+		ref.Err = err.Error()
+		return ref, true
+	}
+	sum, err := c.Prefix().Sum(blk.RawData())
+	if err != nil {
+		ref.Status = BlockError
+		ref.Err = err.Error()
+		return ref, true
+	}
+	if bytes.Equal(sum.Hash(), c.Hash()) {
+		return ref, false
+	}
+	ref.Status = BlockMismatch
+	ref.Err = xerrors.Errorf("data hashes to %s", sum).Error()
+	if !quarantine {
+		return ref, true
+	}
+	if err := a.Node.Datastore.Put(fsckQuarantinePrefix.ChildString(c.String()), blk.RawData()); err != nil {
+		ref.Err += ", quarantine: " + err.Error()
+		return ref, true
+	}
+	if err := a.Node.Blockstore.DeleteBlock(c); err != nil {
+		ref.Err += ", remove from blockstore: " + err.Error()
+		return ref, true
+	}
+	ref.Quarantined = true
+	log.Warnf("quarantined corrupt block %s", c)
+	return ref, true
+}
+
+func (a *RepoAPI) saveFsckCursor(count int64, last cid.Cid) error {
+	v, err := json.Marshal(fsckCursor{
+		Count: count,
+		Key:   last.String(),
+	})
+	if err != nil {
+		return err
+	}
+	return a.Node.Datastore.Put(fsckCursorKey, v)
+}
+
+// RepoStat reports the disk usage of the blockstore as the backend tells
+// it, and the storage limits. Counting the blocks lists the whole
+// blockstore, so it is only done if opts.CountBlocks is set, with the usage
+// of each server for dscluster. The erasure blockstore does not tell which
+// chunk servers keep a block, so it has no breakdown
+func (a *RepoAPI) RepoStat(ctx context.Context, opts api.RepoStatOpts) (*api.RepoStats, error) {
+	n := a.Node
+	st := &api.RepoStats{
+		Backend:     n.Backend,
+		RepoPath:    n.RepoPath,
+		StorageDir:  n.StorageDir,
+		StorageMax:  n.Quota.Max(),
+		GCWatermark: n.Quota.Watermark(),
+		EnableGC:    n.Quota != nil && n.Config.EnableGC,
+	}
+	if n.Storage != nil {
+		du, err := datastore.DiskUsage(n.Storage)
+		if err != nil {
+			return nil, xerrors.Errorf("disk usage: %w", err)
+		}
+		st.DiskUsage = du
+	}
+	if !opts.CountBlocks {
+		return st, nil
+	}
+	var servers *clusterServers
+	if n.Cluster != nil {
+		servers = newClusterServers(n.Cluster)
+	}
+	keys, err := n.Blockstore.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for c := range keys {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		st.Blocks++
+		size, err := n.Blockstore.GetSize(c)
+		if err != nil {
+			log.Warnf("size of %s: %s", c, err)
+			continue
+		}
+		st.Bytes += uint64(size)
+		if servers != nil {
+			if err := servers.add(c, size); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	st.Counted = true
+	if servers != nil {
+		st.Servers = servers.stats
+	}
+	return st, nil
+}
+
+// clusterServers sums the blocks of each dscluster server, a block is kept by
+// the server owning the hash slot of its key, as the cluster client places
+// it
+type clusterServers struct {
+	client *clusterclient.ClusterClient
+	idx    map[string]int
+	stats  []api.ServerStat
+}
+
+func newClusterServers(cluster *node.ClusterStorage) *clusterServers {
+	cs := &clusterServers{
+		client: cluster.Client,
+		idx:    make(map[string]int, len(cluster.Servers)),
+	}
+	for _, nd := range cluster.Servers {
+		cs.idx[nd.ID] = len(cs.stats)
+		cs.stats = append(cs.stats, api.ServerStat{
+			ID:    nd.ID,
+			Addrs: nd.Swarm,
+		})
+	}
+	return cs
+}
+
+func (cs *clusterServers) add(c cid.Cid, size int) error {
+	// the cluster client is mounted under the block prefix, it sees the keys
+	// without it
+	sn, err := cs.client.HashSlots(dshelp.MultihashToDsKey(c.Hash()))
+	if err != nil {
+		return xerrors.Errorf("server of %s: %w", c, err)
+	}
+	i, ok := cs.idx[sn.ID]
+	if !ok {
+		i = len(cs.stats)
+		cs.idx[sn.ID] = i
+		cs.stats = append(cs.stats, api.ServerStat{ID: sn.ID})
+	}
+	cs.stats[i].Blocks++
+	cs.stats[i].Bytes += uint64(size)
+	return nil
+}
@@ -0,0 +1,44 @@
+package impl
+
+import (
+	"context"
+	"testing"
+
+	"github.com/filedrive-team/filejoy/api"
+)
+
+func TestRepoStat(t *testing.T) {
+	n := newTestNode(t)
+	cids := dagCids(t, n, addTestFile(t, n, randData(1, 10<<10), smallChunks))
+	var bytes uint64
+	for _, c := range cids {
+		size, err := n.Blockstore.GetSize(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bytes += uint64(size)
+	}
+	a := &RepoAPI{Node: n}
+
+	st, err := a.RepoStat(context.Background(), api.RepoStatOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Counted || st.Blocks != 0 {
+		t.Errorf("blocks counted without count blocks: %+v", st)
+	}
+
+	st, err = a.RepoStat(context.Background(), api.RepoStatOpts{CountBlocks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Counted || st.Blocks != int64(len(cids)) || st.Bytes != bytes {
+		t.Errorf("counted %d blocks, %d bytes, expected %d blocks, %d bytes", st.Blocks, st.Bytes, len(cids), bytes)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := a.RepoStat(ctx, api.RepoStatOpts{CountBlocks: true}); err != context.Canceled {
+		t.Errorf("count with a cancelled context: %v", err)
+	}
+}
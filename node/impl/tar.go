@@ -0,0 +1,242 @@
+package impl
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/filedrive-team/filejoy/api"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-unixfs"
+	"golang.org/x/xerrors"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// tarBuilder builds a unixfs directory dag from a tar stream, entries are
+// imported as soon as they are read and the directories are built at the
+// end
+type tarBuilder struct {
+	dagServ    format.DAGService
+	cidBuilder cid.Builder
+	opts       api.ImportOpts
+	// shardingSize is the HAMT sharding size of the directories
+	shardingSize int
+	pb           *pbar
+	onFile       func(string, cid.Cid)
+
+	root *tarDir
+	// imported files and symlinks by path, for hard links
+	paths map[string]cid.Cid
+	// nodes which can not be read back from the dag service, only kept in
+	// only hash mode
+	retained map[cid.Cid]format.Node
+}
+
+// tarDir only keeps the cids of its files, so that file data is released
+// as soon as it is imported
+type tarDir struct {
+	meta     FileMeta
+	dirs     map[string]*tarDir
+	children map[string]cid.Cid
+}
+
+func newTarDir() *tarDir {
+	return &tarDir{
+		dirs:     make(map[string]*tarDir),
+		children: make(map[string]cid.Cid),
+	}
+}
+
+// importTar imports the tar stream r, which may be gzip compressed, as a
+// unixfs directory and returns the root node
+func (tb *tarBuilder) importTar(ctx context.Context, r io.Reader) (format.Node, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(len(gzipMagic)); err == nil && bytes.Equal(magic, gzipMagic) {
+		gzr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gzr.Close()
+		r = gzr
+	} else {
+		r = br
+	}
+
+	tb.root = newTarDir()
+	tb.paths = make(map[string]cid.Cid)
+	if _, ok := tb.dagServ.(*DiscardDAG); ok {
+		tb.retained = make(map[cid.Cid]format.Node)
+	}
+	tr := tar.NewReader(r)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := tb.addEntry(ctx, hdr, tr); err != nil {
+			return nil, xerrors.Errorf("%s: %w", hdr.Name, err)
+		}
+	}
+	return tb.buildDir(ctx, tb.root)
+}
+
+// cleanTarPath returns the relative path of a tar entry, paths leaving the
+// archive root are rejected
+func cleanTarPath(name string) (string, error) {
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", xerrors.Errorf("invalid path: %s", name)
+		}
+	}
+	return strings.TrimPrefix(path.Clean("/"+name), "/"), nil
+}
+
+func (tb *tarBuilder) addEntry(ctx context.Context, hdr *tar.Header, tr *tar.Reader) error {
+	p, err := cleanTarPath(hdr.Name)
+	if err != nil {
+		return err
+	}
+	meta := FileMeta{
+		Mode:    PosixMode(hdr.FileInfo().Mode()),
+		ModTime: hdr.ModTime,
+	}
+	if p == "" {
+		if hdr.Typeflag == tar.TypeDir {
+			tb.root.meta = meta
+		}
+		return nil
+	}
+	dir, name := path.Split(p)
+	parent := tb.dir(strings.TrimSuffix(dir, "/"))
+
+	var nd format.Node
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		d := tb.dir(p)
+		d.meta = meta
+		return nil
+	case tar.TypeReg:
+		nd, err = BuildFileNode(ctx, io.TeeReader(tr, tb.pb), tb.dagServ, tb.opts, meta)
+		if err != nil {
+			return err
+		}
+		if tb.onFile != nil {
+			tb.onFile(p, nd.Cid())
+		}
+	case tar.TypeSymlink:
+		data, err := unixfs.SymlinkData(hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		pn := merkledag.NodeWithData(data)
+		pn.SetCidBuilder(tb.cidBuilder)
+		if err := tb.dagServ.Add(ctx, pn); err != nil {
+			return err
+		}
+		nd = pn
+	case tar.TypeLink:
+		target, err := cleanTarPath(hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		c, ok := tb.paths[target]
+		if !ok {
+			return xerrors.Errorf("hard link target %s not found", hdr.Linkname)
+		}
+		delete(parent.dirs, name)
+		parent.children[name] = c
+		tb.paths[p] = c
+		return nil
+	case tar.TypeXGlobalHeader:
+		return nil
+	default:
+		log.Warnf("ignore %s: unsupported tar entry type %c", hdr.Name, hdr.Typeflag)
+		return nil
+	}
+	if tb.retained != nil {
+		tb.retained[nd.Cid()] = nd
+	}
+	delete(parent.dirs, name)
+	parent.children[name] = nd.Cid()
+	tb.paths[p] = nd.Cid()
+	return nil
+}
+
+// dir returns the directory at p, missing parents are created
+func (tb *tarBuilder) dir(p string) *tarDir {
+	d := tb.root
+	if p == "" {
+		return d
+	}
+	for _, name := range strings.Split(p, "/") {
+		sub, ok := d.dirs[name]
+		if !ok {
+			sub = newTarDir()
+			d.dirs[name] = sub
+			delete(d.children, name)
+		}
+		d = sub
+	}
+	return d
+}
+
+func (tb *tarBuilder) buildDir(ctx context.Context, d *tarDir) (format.Node, error) {
+	dir, err := newShardedDir(tb.dagServ, tb.cidBuilder, tb.shardingSize)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(d.dirs))
+	for name := range d.dirs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		nd, err := tb.buildDir(ctx, d.dirs[name])
+		if err != nil {
+			return nil, err
+		}
+		if err := dir.AddChild(ctx, name, nd); err != nil {
+			return nil, err
+		}
+	}
+	for name, c := range d.children {
+		nd, ok := tb.retained[c]
+		if !ok {
+			var err error
+			if nd, err = tb.dagServ.Get(ctx, c); err != nil {
+				return nil, err
+			}
+		}
+		if err := dir.AddChild(ctx, name, nd); err != nil {
+			return nil, err
+		}
+	}
+	nd, err := dir.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	if nd, err = withMeta(nd, d.meta, tb.cidBuilder); err != nil {
+		return nil, err
+	}
+	if err := tb.dagServ.Add(ctx, nd); err != nil {
+		return nil, err
+	}
+	return nd, nil
+}
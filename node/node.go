@@ -13,6 +13,8 @@ import (
 	"github.com/filedag-project/trans"
 	"github.com/filedrive-team/filejoy/gateway"
 	ncfg "github.com/filedrive-team/filejoy/node/config"
+	"github.com/filedrive-team/filejoy/node/filestore"
+	"github.com/filedrive-team/filejoy/node/pinner"
 	"github.com/filedrive-team/go-ds-cluster/clusterclient"
 	dsccfg "github.com/filedrive-team/go-ds-cluster/config"
 	dsccore "github.com/filedrive-team/go-ds-cluster/core"
@@ -42,6 +44,7 @@ import (
 	libp2pquic "github.com/libp2p/go-libp2p-quic-transport"
 	"github.com/multiformats/go-multiaddr"
 	badgerds "github.com/textileio/go-ds-badger3"
+	"golang.org/x/xerrors"
 )
 
 var log = logging.Logger("filejoy-node")
@@ -58,8 +61,8 @@ type Node struct {
 	FullRT *fullrt.FullRT
 	Host   host.Host
 
-	// Set for gathering disk usage
-
+	// StorageDir is the blockstore directory for gathering disk usage, empty
+	// unless the blocks are kept in the local badger
 	StorageDir string
 	//Lmdb      *lmdb.Blockstore
 	Datastore datastore.Batching
@@ -67,6 +70,22 @@ type Node struct {
 	Blockstore blockstore.Blockstore
 	Bitswap    *bitswap.Bitswap
 	Dagserv    format.DAGService
+	// Filestore is only set when enabled in config, Blockstore is it then
+	Filestore *filestore.Filestore
+	Pinner    *pinner.Pinner
+	// GCLocker keeps garbage collection from running during imports
+	GCLocker blockstore.GCLocker
+	// Storage is the datastore the blocks are kept in, nil for the erasure
+	// blockstore
+	Storage datastore.Datastore
+	// Quota is nil if the storage is not limited
+	Quota *Quota
+	// Backend is the storage backend, one of the Backend constants
+	Backend string
+	// Cluster is the dscluster client keeping the blocks, nil for the other
+	// backends
+	Cluster  *ClusterStorage
+	RepoPath string
 
 	Config       *ncfg.Config
 	RemotedsServ dsccore.DataNodeServer
@@ -178,7 +197,38 @@ func Setup(ctx context.Context, cfg *ncfg.Config, repoPath string) (*Node, error
 
 	var blkst blockstore.Blockstore
 	var cds datastore.Datastore
-	cds, blkst, err = ConfigStorage(ctx, cfg, repoPath)
+	var cluster *ClusterStorage
+	cds, blkst, cluster, err = configStorage(ctx, cfg, repoPath)
+	if err != nil {
+		return nil, err
+	}
+	backend := StorageBackend(cfg, repoPath)
+	var storageDir string
+	if backend == BackendBadger {
+		storageDir = filepath.Join(repoPath, cfg.Blockstore)
+	}
+	quota, err := newQuota(cfg, filepath.Join(repoPath, cfg.Blockstore))
+	if err != nil {
+		return nil, err
+	}
+	if quota != nil {
+		if backend != BackendBadger {
+			log.Warnf("storage_max is only enforced for the badger blockstore, not for %s", backend)
+			quota = nil
+		} else {
+			blkst = &quotaBlockstore{
+				Blockstore: blkst,
+				q:          quota,
+			}
+		}
+	}
+
+	var fstore *filestore.Filestore
+	if cfg.EnableFilestore {
+		fstore = filestore.New(blkst, lds)
+		blkst = fstore
+		log.Info("filestore enabled")
+	}
 
 	bsnet := bsnet.NewFromIpfsHost(h, frt)
 
@@ -202,7 +252,7 @@ func Setup(ctx context.Context, cfg *ncfg.Config, repoPath string) (*Node, error
 	var gatewayServ *http.Server
 	if cfg.GateWayPort > 0 {
 		log.Info(cfg.GateWayPort)
-		router := gateway.InitRouter(ctx, dagServ)
+		router := gateway.InitRouter(ctx, dagServ, cfg.PrefetchWindow)
 
 		addr := fmt.Sprintf(":%d", cfg.GateWayPort)
 		maxHeaderBytes := 1 << 20
@@ -229,6 +279,15 @@ func Setup(ctx context.Context, cfg *ncfg.Config, repoPath string) (*Node, error
 		FullRT:       frt,
 		Host:         h,
 		Blockstore:   blkst,
+		Filestore:    fstore,
+		Pinner:       pinner.New(lds),
+		GCLocker:     blockstore.NewGCLocker(),
+		Storage:      cds,
+		Quota:        quota,
+		Backend:      backend,
+		Cluster:      cluster,
+		RepoPath:     repoPath,
+		StorageDir:   storageDir,
 		Datastore:    lds,
 		Bitswap:      bswap.(*bitswap.Bitswap),
 		Dagserv:      dagServ,
@@ -253,19 +312,80 @@ func (n *Node) Close() (err error) {
 }
 
 func ConfigStorage(ctx context.Context, cfg *ncfg.Config, repoPath string) (datastore.Datastore, blockstore.Blockstore, error) {
+	cds, blkst, _, err := configStorage(ctx, cfg, repoPath)
+	return cds, blkst, err
+}
+
+// ClusterStorage is the dscluster client keeping the blocks and the servers
+// it was set up with
+type ClusterStorage struct {
+	Client  *clusterclient.ClusterClient
+	Servers []dsccfg.Node
+}
+
+// configStorage is ConfigStorage which also returns the dscluster client, if
+// the blocks are kept in a dscluster
+func configStorage(ctx context.Context, cfg *ncfg.Config, repoPath string) (datastore.Datastore, blockstore.Blockstore, *ClusterStorage, error) {
 	if len(cfg.Erasure.ChunkServers) > 0 {
 		blkst, err := trans.NewErasureBlockstore(ctx, cfg.Erasure.ChunkServers, cfg.Erasure.ConnNum, cfg.Erasure.DataShard, cfg.Erasure.ParShard, cfg.Erasure.Batch, "")
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 		log.Info("erasure blockstore")
-		return nil, blkst, nil
+		return nil, blkst, nil, nil
 	}
 	return blockstoreFromDatastore(ctx, cfg, repoPath)
 }
 
-func blockstoreFromDatastore(ctx context.Context, cfg *ncfg.Config, repoPath string) (datastore.Datastore, blockstore.Blockstore, error) {
+// storage backends
+const (
+	BackendBadger    = "badger"
+	BackendDSCluster = "dscluster"
+	BackendRemoteDS  = "remoteds"
+	BackendErasure   = "erasure"
+)
+
+// StorageBackend tells which backend ConfigStorage uses for the config
+func StorageBackend(cfg *ncfg.Config, repoPath string) string {
+	if len(cfg.Erasure.ChunkServers) > 0 {
+		return BackendErasure
+	}
+	if _, err := os.Stat(filepath.Join(repoPath, cfg.DSClusterConf)); err == nil {
+		return BackendDSCluster
+	}
+	if _, err := os.Stat(filepath.Join(repoPath, cfg.RemoteDSConf)); err == nil {
+		return BackendRemoteDS
+	}
+	return BackendBadger
+}
+
+// OpenFilestore wraps bs with the filestore kept in the node's datastore, for
+// commands running without the daemon. The returned closer releases the
+// datastore
+func OpenFilestore(cfg *ncfg.Config, repoPath string, bs blockstore.Blockstore) (*filestore.Filestore, func() error, error) {
+	if !cfg.EnableFilestore {
+		return nil, nil, xerrors.New("filestore is not enabled, set enable_filestore in config")
+	}
+	lds, err := OpenDatastore(cfg, repoPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return filestore.New(bs, lds), lds.Close, nil
+}
+
+// OpenDatastore opens the node's datastore, which keeps the pins and the
+// filestore references, for commands running without the daemon
+func OpenDatastore(cfg *ncfg.Config, repoPath string) (*levelds.Datastore, error) {
+	lds, err := levelds.NewDatastore(filepath.Join(repoPath, cfg.Datastore), nil)
+	if err != nil {
+		return nil, xerrors.Errorf("open datastore, is the daemon running? %w", err)
+	}
+	return lds, nil
+}
+
+func blockstoreFromDatastore(ctx context.Context, cfg *ncfg.Config, repoPath string) (datastore.Datastore, blockstore.Blockstore, *ClusterStorage, error) {
 	var cds datastore.Datastore
+	var cluster *ClusterStorage
 	var err error
 	dsclustercfgpath := filepath.Join(repoPath, cfg.DSClusterConf)
 	remotedscfgpath := filepath.Join(repoPath, cfg.RemoteDSConf)
@@ -275,26 +395,31 @@ func blockstoreFromDatastore(ctx context.Context, cfg *ncfg.Config, repoPath str
 	if dscerr == nil {
 		dcfg, err := dsccfg.ReadConfig(dsclustercfgpath)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 
-		cds, err = clusterclient.NewClusterClient(ctx, dcfg)
+		client, err := clusterclient.NewClusterClient(ctx, dcfg)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
+		}
+		cds = client
+		cluster = &ClusterStorage{
+			Client:  client,
+			Servers: dcfg.Nodes,
 		}
 		log.Info("use dscluster as blockstore")
 	} else if rdserr == nil {
 		rcfg, err := remoteclient.ReadConfig(remotedscfgpath)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 		h, err := remoteclient.HostForRemoteClient(rcfg)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 		cds, err = remoteclient.NewRemoteStore(ctx, h, rcfg.Target, rcfg.Timeout, rcfg.AccessToken)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 		useRemoteStore = true
 		log.Info("use remoteds as blockstore")
@@ -302,16 +427,16 @@ func blockstoreFromDatastore(ctx context.Context, cfg *ncfg.Config, repoPath str
 		if os.IsNotExist(dscerr) && os.IsNotExist(rdserr) {
 			p := filepath.Join(repoPath, cfg.Blockstore)
 			if err := os.MkdirAll(p, 0755); err != nil {
-				return nil, nil, err
+				return nil, nil, nil, err
 			}
 			opts := badgerds.DefaultOptions
 			cds, err = badgerds.NewDatastore(p, &opts)
 			if err != nil {
-				return nil, nil, err
+				return nil, nil, nil, err
 			}
 			log.Info("use badger as blockstore")
 		} else {
-			return nil, nil, dscerr
+			return nil, nil, nil, dscerr
 		}
 	}
 	if !useRemoteStore {
@@ -329,5 +454,5 @@ func blockstoreFromDatastore(ctx context.Context, cfg *ncfg.Config, repoPath str
 	} else {
 		blkst = blockstore.NewBlockstore(cds.(*dsmount.Datastore))
 	}
-	return cds, blkst, nil
+	return cds, blkst, cluster, nil
 }
@@ -0,0 +1,172 @@
+package pinner
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	"golang.org/x/xerrors"
+)
+
+// PinPrefix is the datastore namespace of the pins
+var PinPrefix = datastore.NewKey("/pins")
+
+// pin modes
+const (
+	// Direct pins keep only the block itself
+	Direct = "direct"
+	// Recursive pins keep the whole dag below the block
+	Recursive = "recursive"
+)
+
+// ErrNotPinned is returned when a cid has no pin
+var ErrNotPinned = xerrors.New("not pinned")
+
+// Pin records that a block, or the dag below it, is to be kept
+type Pin struct {
+	Cid  cid.Cid `json:"cid"`
+	Mode string  `json:"mode"`
+	// Name groups pins into named sets, it need not be unique
+	Name    string            `json:"name,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Created time.Time         `json:"created"`
+}
+
+// Pinner keeps the pins in a datastore, one record per cid
+type Pinner struct {
+	// mu serializes the read-modify-write of pin records
+	mu sync.Mutex
+	ds datastore.Batching
+}
+
+// New keeps the pins under PinPrefix of ds
+func New(ds datastore.Batching) *Pinner {
+	return &Pinner{
+		ds: namespace.Wrap(ds, PinPrefix),
+	}
+}
+
+func pinKey(c cid.Cid) datastore.Key {
+	return datastore.NewKey(c.String())
+}
+
+// Pin adds the pin p. Pinning a pinned cid again replaces its name and
+// labels, a recursive pin is never downgraded to a direct one
+func (p *Pinner) Pin(pin *Pin) error {
+	if pin.Mode != Direct && pin.Mode != Recursive {
+		return xerrors.Errorf("unknown pin mode %q", pin.Mode)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rec := *pin
+	old, err := p.get(pin.Cid)
+	switch {
+	case err == nil:
+		rec.Created = old.Created
+		if old.Mode == Recursive {
+			rec.Mode = Recursive
+		}
+	case err == ErrNotPinned:
+		if rec.Created.IsZero() {
+			rec.Created = time.Now()
+		}
+	default:
+		return err
+	}
+	v, err := json.Marshal(&rec)
+	if err != nil {
+		return err
+	}
+	return p.ds.Put(pinKey(pin.Cid), v)
+}
+
+// Unpin removes the pin of c
+func (p *Pinner) Unpin(c cid.Cid) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	k := pinKey(c)
+	has, err := p.ds.Has(k)
+	if err != nil {
+		return err
+	}
+	if !has {
+		return ErrNotPinned
+	}
+	return p.ds.Delete(k)
+}
+
+// Get returns the pin of c, ErrNotPinned if there is none
+func (p *Pinner) Get(c cid.Cid) (*Pin, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.get(c)
+}
+
+func (p *Pinner) get(c cid.Cid) (*Pin, error) {
+	v, err := p.ds.Get(pinKey(c))
+	if err != nil {
+		if err == datastore.ErrNotFound {
+			return nil, ErrNotPinned
+		}
+		return nil, err
+	}
+	pin := &Pin{}
+	if err := json.Unmarshal(v, pin); err != nil {
+		return nil, xerrors.Errorf("decode pin %s: %w", c, err)
+	}
+	return pin, nil
+}
+
+// List returns all the pins
+func (p *Pinner) List() ([]*Pin, error) {
+	res, err := p.ds.Query(query.Query{})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	var pins []*Pin
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		pin := &Pin{}
+		if err := json.Unmarshal(r.Value, pin); err != nil {
+			return nil, xerrors.Errorf("decode pin %s: %w", r.Key, err)
+		}
+		pins = append(pins, pin)
+	}
+	return pins, nil
+}
+
+// Empty tells whether there is no pin at all
+func (p *Pinner) Empty() (bool, error) {
+	res, err := p.ds.Query(query.Query{KeysOnly: true, Limit: 1})
+	if err != nil {
+		return false, err
+	}
+	defer res.Close()
+	for r := range res.Next() {
+		if r.Error != nil {
+			return false, r.Error
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// Match tells whether the pin has the name, if set, and all the labels
+func (pin *Pin) Match(name string, labels map[string]string) bool {
+	if name != "" && pin.Name != name {
+		return false
+	}
+	for k, v := range labels {
+		if lv, ok := pin.Labels[k]; !ok || lv != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,156 @@
+package pinner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	levelds "github.com/ipfs/go-ds-leveldb"
+	mh "github.com/multiformats/go-multihash"
+)
+
+func testCid(t *testing.T, data string) cid.Cid {
+	t.Helper()
+	h, err := mh.Sum([]byte(data), mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cid.NewCidV1(cid.Raw, h)
+}
+
+func TestPinnerPersists(t *testing.T) {
+	dir := t.TempDir()
+	ds, err := levelds.NewDatastore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := testCid(t, "a")
+	pin := &Pin{
+		Cid:    c,
+		Mode:   Recursive,
+		Name:   "photos",
+		Labels: map[string]string{"year": "2021"},
+	}
+	if err := New(ds).Pin(pin); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ds, err = levelds.NewDatastore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+	got, err := New(ds).Get(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Cid.Equals(c) || got.Mode != Recursive || got.Name != "photos" || got.Labels["year"] != "2021" || got.Created.IsZero() {
+		t.Fatalf("reopened pin: %+v", got)
+	}
+}
+
+func TestPinnerRepin(t *testing.T) {
+	p := New(dssync.MutexWrap(datastore.NewMapDatastore()))
+	c := testCid(t, "a")
+	if err := p.Pin(&Pin{Cid: c, Mode: Recursive, Name: "old"}); err != nil {
+		t.Fatal(err)
+	}
+	first, err := p.Get(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	// pinning again renames the pin, a recursive pin stays recursive
+	if err := p.Pin(&Pin{Cid: c, Mode: Direct, Name: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.Get(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Mode != Recursive {
+		t.Errorf("recursive pin downgraded to %s", got.Mode)
+	}
+	if got.Name != "new" {
+		t.Errorf("repinned name %q, expected new", got.Name)
+	}
+	if !got.Created.Equal(first.Created) {
+		t.Errorf("repinning changed the creation time from %s to %s", first.Created, got.Created)
+	}
+
+	if err := p.Pin(&Pin{Cid: c, Mode: "shallow"}); err == nil {
+		t.Error("unknown pin mode accepted")
+	}
+}
+
+func TestPinnerUnpin(t *testing.T) {
+	p := New(dssync.MutexWrap(datastore.NewMapDatastore()))
+	empty, err := p.Empty()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !empty {
+		t.Fatal("new pinner is not empty")
+	}
+	a, b := testCid(t, "a"), testCid(t, "b")
+	for _, c := range []cid.Cid{a, b} {
+		if err := p.Pin(&Pin{Cid: c, Mode: Direct}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Unpin(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Unpin(a); err != ErrNotPinned {
+		t.Errorf("unpin twice: %v, expected %v", err, ErrNotPinned)
+	}
+	if _, err := p.Get(a); err != ErrNotPinned {
+		t.Errorf("get unpinned: %v, expected %v", err, ErrNotPinned)
+	}
+	pins, err := p.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pins) != 1 || !pins[0].Cid.Equals(b) {
+		t.Fatalf("pins left: %v", pins)
+	}
+	if empty, err := p.Empty(); err != nil || empty {
+		t.Fatalf("empty with a pin left: %t, %v", empty, err)
+	}
+	if err := p.Unpin(b); err != nil {
+		t.Fatal(err)
+	}
+	if empty, err := p.Empty(); err != nil || !empty {
+		t.Fatalf("empty with no pin left: %t, %v", empty, err)
+	}
+}
+
+func TestPinMatch(t *testing.T) {
+	pin := &Pin{
+		Name:   "photos",
+		Labels: map[string]string{"year": "2021", "camera": "x100"},
+	}
+	for _, tc := range []struct {
+		name   string
+		labels map[string]string
+		match  bool
+	}{
+		{"", nil, true},
+		{"photos", nil, true},
+		{"videos", nil, false},
+		{"", map[string]string{"year": "2021"}, true},
+		{"photos", map[string]string{"year": "2021", "camera": "x100"}, true},
+		{"", map[string]string{"year": "2020"}, false},
+		{"", map[string]string{"place": ""}, false},
+		{"videos", map[string]string{"year": "2021"}, false},
+	} {
+		if got := pin.Match(tc.name, tc.labels); got != tc.match {
+			t.Errorf("match name %q labels %v: %t, expected %t", tc.name, tc.labels, got, tc.match)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package node
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	ncfg "github.com/filedrive-team/filejoy/node/config"
+	blocks "github.com/ipfs/go-block-format"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"golang.org/x/xerrors"
+)
+
+// ErrStorageFull is returned for the writes refused once the blockstore
+// reaches StorageMax
+var ErrStorageFull = xerrors.New("storage max reached")
+
+// defaultGCWatermark is the percentage of StorageMax over which gc is run
+// if the config does not set it
+const defaultGCWatermark = 90
+
+// quotaMeasureInterval is how often the size of the blockstore is measured
+// again
+const quotaMeasureInterval = time.Minute
+
+// Quota tracks the size of the blockstore against the StorageMax of the
+// config. The size is measured in the background every so often and the
+// blocks put since are added to it, so it is approximate
+type Quota struct {
+	max       uint64
+	watermark uint64
+	usage     func() (uint64, error)
+
+	mu   sync.Mutex
+	size uint64
+	// measured is when the last measure started
+	measured  time.Time
+	measuring bool
+	// gc is run once the size goes over the watermark
+	gc        func()
+	gcRunning bool
+}
+
+// newQuota returns the quota of the config, nil if there is no limit. The
+// size is the disk usage of the files under dir
+func newQuota(cfg *ncfg.Config, dir string) (*Quota, error) {
+	if cfg.StorageMax == "" {
+		return nil, nil
+	}
+	max, err := humanize.ParseBytes(cfg.StorageMax)
+	if err != nil {
+		return nil, xerrors.Errorf("parse storage_max: %w", err)
+	}
+	if max == 0 {
+		return nil, nil
+	}
+	wm := cfg.StorageGCWatermark
+	if wm <= 0 {
+		wm = defaultGCWatermark
+	}
+	if wm > 100 {
+		return nil, xerrors.Errorf("storage_gc_watermark %d is over 100", wm)
+	}
+	q := &Quota{
+		max:       max,
+		watermark: max / 100 * uint64(wm),
+		usage: func() (uint64, error) {
+			return diskUsage(dir)
+		},
+		measured:  time.Now(),
+		measuring: true,
+	}
+	go q.measure()
+	return q, nil
+}
+
+// SetGC sets the gc run in the background once the size goes over the
+// watermark, writes are refused at StorageMax otherwise
+func (q *Quota) SetGC(gc func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.gc = gc
+}
+
+// Max returns StorageMax in bytes, 0 if there is no limit
+func (q *Quota) Max() uint64 {
+	if q == nil {
+		return 0
+	}
+	return q.max
+}
+
+// Watermark returns the size over which gc is run, in bytes
+func (q *Quota) Watermark() uint64 {
+	if q == nil {
+		return 0
+	}
+	return q.watermark
+}
+
+// Check returns ErrStorageFull if StorageMax is reached, the n bytes about
+// to be written are added to the size otherwise. A nil quota has no limit.
+// Only the counters are compared, the size is measured in the background
+func (q *Quota) Check(n int) error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.measuring && time.Since(q.measured) > quotaMeasureInterval {
+		q.measuring = true
+		q.measured = time.Now()
+		go q.measure()
+	}
+	if q.size >= q.watermark && q.gc != nil && !q.gcRunning {
+		q.gcRunning = true
+		go q.runGC(q.gc)
+	}
+	if q.size >= q.max {
+		return xerrors.Errorf("%s of %s used: %w", humanize.Bytes(q.size), humanize.Bytes(q.max), ErrStorageFull)
+	}
+	q.size += uint64(n)
+	return nil
+}
+
+// measure replaces the size with the disk usage, the blocks put during the
+// walk may be missed until the next measure
+func (q *Quota) measure() {
+	size, err := q.usage()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.measuring = false
+	if err != nil {
+		log.Warnf("measure storage usage: %s", err)
+		return
+	}
+	q.size = size
+}
+
+func (q *Quota) runGC(gc func()) {
+	log.Infof("storage usage is over the gc watermark of %s, running gc", humanize.Bytes(q.watermark))
+	gc()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.gcRunning = false
+	// measure the freed space on the next check
+	q.measured = time.Time{}
+}
+
+// diskUsage returns the space taken by the files under dir
+func diskUsage(dir string) (uint64, error) {
+	var total uint64
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			total += fileUsage(info)
+		}
+		return nil
+	})
+	return total, err
+}
+
+// quotaBlockstore refuses to put new blocks once the quota is reached
+type quotaBlockstore struct {
+	blockstore.Blockstore
+	q *Quota
+}
+
+func (bs *quotaBlockstore) Put(b blocks.Block) error {
+	// blocks already stored take no more space
+	if has, err := bs.Has(b.Cid()); err == nil && has {
+		return nil
+	}
+	if err := bs.q.Check(len(b.RawData())); err != nil {
+		return err
+	}
+	return bs.Blockstore.Put(b)
+}
+
+func (bs *quotaBlockstore) PutMany(blks []blocks.Block) error {
+	n := 0
+	for _, b := range blks {
+		if has, err := bs.Has(b.Cid()); err == nil && has {
+			continue
+		}
+		n += len(b.RawData())
+	}
+	if n == 0 {
+		return nil
+	}
+	if err := bs.q.Check(n); err != nil {
+		return err
+	}
+	return bs.Blockstore.PutMany(blks)
+}